@@ -0,0 +1,580 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+// Package asm assembles 6502 source into machine code, using the instruction set of the munch CPU
+// so anything Disassemble prints assembles back to the same instruction.
+//
+// Each line holds an optional label, then an instruction or directive, then an optional comment
+// starting with ;. Labels end with a colon, and labels starting with @ are local to the label
+// before them. Symbols are defined with name = expr. The directives are .org, .byte, which takes
+// strings in double quotes as well as expressions, and .word. As in ca65, .setcpu is accepted and
+// ignored and an address starting with a: is always assembled as absolute rather than zero page.
+//
+// Expressions use $hex, %binary, decimal and 'c' numbers, * for the address of the current line,
+// the unary operators -, ~, < for the low byte and > for the high byte, and the binary operators
+// * / + - << >> & ^ | with the usual precedence. Operands follow Disassemble, so LDA ($12), Y and
+// LDA ($12),Y are the same. Accumulator instructions can be written as ASL or ASL A. Unofficial
+// opcodes can be written with or without the * that Disassemble adds.
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/noddy76/munch"
+)
+
+// Program is the result of assembling some source.
+type Program struct {
+	Segments []Segment
+	Symbols  map[string]uint16 // local labels are named label@local
+}
+
+// Segment is code assembled at an address, started by .org.
+type Segment struct {
+	Addr  uint16
+	Bytes []uint8
+}
+
+// Load writes the program into memory, usually a Bus, with Poke so ROMs can be loaded.
+func (p *Program) Load(mem munch.Poker) {
+	for _, s := range p.Segments {
+		for i, v := range s.Bytes {
+			mem.Poke(s.Addr+uint16(i), v)
+		}
+	}
+}
+
+// Bytes returns the program from its lowest address to its highest, with any gaps between
+// segments filled with zeros, and the address it starts at.
+func (p *Program) Bytes() ([]uint8, uint16) {
+	if len(p.Segments) == 0 {
+		return nil, 0
+	}
+	start, end := 0x10000, 0
+	for _, s := range p.Segments {
+		if len(s.Bytes) == 0 {
+			continue
+		}
+		if int(s.Addr) < start {
+			start = int(s.Addr)
+		}
+		if e := int(s.Addr) + len(s.Bytes); e > end {
+			end = e
+		}
+	}
+	if end == 0 {
+		return nil, 0
+	}
+	bytes := make([]uint8, end-start)
+	for _, s := range p.Segments {
+		copy(bytes[int(s.Addr)-start:], s.Bytes)
+	}
+	return bytes, uint16(start)
+}
+
+// Error is an error in a line of the source.
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+type assembler struct {
+	// instructions by mnemonic without * and mode, official and unofficial
+	ops        map[string]map[string]munch.Instruction
+	unofficial map[string]map[string]munch.Instruction
+
+	pass    int
+	line    int
+	pc      uint16
+	scope   string         // the last global label
+	symbols map[string]int // values of defined symbols
+	modes   map[int]string // mode chosen for each instruction in the first pass
+	prog    *Program
+}
+
+// Assemble assembles src for the given variant of the 6502.
+func Assemble(src string, variant munch.Variant) (*Program, error) {
+	a := &assembler{
+		ops:        map[string]map[string]munch.Instruction{},
+		unofficial: map[string]map[string]munch.Instruction{},
+		symbols:    map[string]int{},
+		modes:      map[int]string{},
+	}
+	for _, in := range munch.Instructions(variant) {
+		ops := a.ops
+		if strings.HasSuffix(in.Mnemonic, "*") {
+			ops = a.unofficial
+		}
+		mne := strings.TrimSuffix(in.Mnemonic, "*")
+		if ops[mne] == nil {
+			ops[mne] = map[string]munch.Instruction{}
+		}
+		if _, ok := ops[mne][in.Mode]; !ok {
+			ops[mne][in.Mode] = in
+		}
+	}
+
+	lines := strings.Split(src, "\n")
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.pc, a.scope = 0, ""
+		a.prog = &Program{Symbols: map[string]uint16{}}
+		for i, line := range lines {
+			a.line = i + 1
+			if err := a.assembleLine(line); err != nil {
+				return nil, &Error{Line: a.line, Err: err}
+			}
+		}
+	}
+
+	for name, v := range a.symbols {
+		a.prog.Symbols[name] = uint16(v)
+	}
+	sort.Slice(a.prog.Segments, func(i, j int) bool { return a.prog.Segments[i].Addr < a.prog.Segments[j].Addr })
+	return a.prog, nil
+}
+
+// symbolName gives the full name of a symbol, putting local labels in the scope of the last
+// global one.
+func (a *assembler) symbolName(name string) string {
+	if strings.HasPrefix(name, "@") {
+		return a.scope + name
+	}
+	return name
+}
+
+func (a *assembler) lookup(name string) (int, bool, error) {
+	v, ok := a.symbols[a.symbolName(name)]
+	if !ok && a.pass == 2 {
+		return 0, false, fmt.Errorf("undefined symbol %s", name)
+	}
+	return v, ok, nil
+}
+
+func (a *assembler) define(name string, v int) error {
+	full := a.symbolName(name)
+	if prev, ok := a.symbols[full]; ok && (a.pass == 1 || prev != v) {
+		return fmt.Errorf("%s defined twice", name)
+	}
+	a.symbols[full] = v
+	if !strings.HasPrefix(name, "@") {
+		a.scope = name
+	}
+	return nil
+}
+
+// stripComment removes a comment, ignoring semicolons in strings and character constants.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'' && i > 0 && line[i-1] == '\'':
+			// the character in a constant such as ';'
+			quote = 0
+			if c == '\'' {
+				continue
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (a *assembler) assembleLine(line string) error {
+	line = strings.TrimSpace(stripComment(line))
+
+	// label:
+	if n := identLength(line); n > 0 && n < len(line) && line[n] == ':' {
+		if err := a.define(line[:n], int(a.pc)); err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line[n+1:])
+	}
+	if line == "" {
+		return nil
+	}
+
+	// name = expr
+	if n := identLength(line); n > 0 && strings.HasPrefix(strings.TrimSpace(line[n:]), "=") {
+		name := line[:n]
+		v, known, err := a.eval(strings.TrimSpace(line[n:])[1:])
+		if err != nil || !known {
+			return err
+		}
+		full := a.symbolName(name)
+		if prev, ok := a.symbols[full]; ok && prev != v {
+			return fmt.Errorf("%s defined twice", name)
+		}
+		a.symbols[full] = v
+		return nil
+	}
+
+	word, operand := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		word, operand = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	if strings.HasPrefix(word, ".") {
+		return a.directive(strings.ToLower(word), operand)
+	}
+	return a.instruction(strings.ToUpper(word), operand)
+}
+
+// opcodes gives the instructions for mne by addressing mode. Mnemonics ending in * prefer
+// unofficial opcodes, others official ones, and either falls back on the other.
+func (a *assembler) opcodes(mne string) map[string]munch.Instruction {
+	first, second := a.ops, a.unofficial
+	if strings.HasSuffix(mne, "*") {
+		first, second = second, first
+	}
+	mne = strings.TrimSuffix(mne, "*")
+	if first[mne] == nil {
+		return second[mne]
+	}
+	if second[mne] == nil {
+		return first[mne]
+	}
+	modes := map[string]munch.Instruction{}
+	for m, in := range second[mne] {
+		modes[m] = in
+	}
+	for m, in := range first[mne] {
+		modes[m] = in
+	}
+	return modes
+}
+
+// identLength returns the length of the symbol name at the start of s.
+func identLength(s string) int {
+	n := 0
+	if n < len(s) && s[n] == '@' {
+		n++
+	}
+	if n == len(s) || !isIdentStart(s[n]) {
+		return 0
+	}
+	for n < len(s) && isIdent(s[n]) {
+		n++
+	}
+	return n
+}
+
+func (a *assembler) emit(bytes ...uint8) {
+	if len(a.prog.Segments) == 0 {
+		a.prog.Segments = append(a.prog.Segments, Segment{Addr: a.pc})
+	}
+	s := &a.prog.Segments[len(a.prog.Segments)-1]
+	s.Bytes = append(s.Bytes, bytes...)
+	a.pc += uint16(len(bytes))
+}
+
+func (a *assembler) directive(name string, operand string) error {
+	switch name {
+	case ".org":
+		v, known, err := a.eval(operand)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return errors.New(".org must not refer to symbols defined later")
+		}
+		if v < 0 || v > 0xffff {
+			return fmt.Errorf(".org $%x is out of range", v)
+		}
+		a.pc = uint16(v)
+		a.prog.Segments = append(a.prog.Segments, Segment{Addr: a.pc})
+		return nil
+	case ".byte", ".word":
+		args, err := splitArgs(operand)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs a value", name)
+		}
+		for _, arg := range args {
+			if name == ".byte" && strings.HasPrefix(arg, "\"") {
+				if len(arg) < 2 || !strings.HasSuffix(arg, "\"") {
+					return fmt.Errorf("unterminated string %s", arg)
+				}
+				a.emit([]uint8(arg[1 : len(arg)-1])...)
+				continue
+			}
+			v, _, err := a.eval(arg)
+			if err != nil {
+				return err
+			}
+			if name == ".byte" {
+				b, err := a.byteValue(v)
+				if err != nil {
+					return err
+				}
+				a.emit(b)
+			} else {
+				w, err := a.wordValue(v)
+				if err != nil {
+					return err
+				}
+				a.emit(uint8(w), uint8(w>>8))
+			}
+		}
+		return nil
+	case ".setcpu":
+		return nil
+	}
+	return fmt.Errorf("unknown directive %s", name)
+}
+
+// splitArgs splits s at commas that aren't in strings or brackets.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '\'' && i+2 < len(s) && s[i+2] == '\'':
+			i += 2
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated string")
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(args) > 0 {
+		args = append(args, last)
+	}
+	return args, nil
+}
+
+// byteValue checks v fits in a byte, allowing negative numbers. Values aren't checked in the
+// first pass as they may depend on symbols that aren't defined yet.
+func (a *assembler) byteValue(v int) (uint8, error) {
+	if a.pass == 2 && (v < -0x80 || v > 0xff) {
+		return 0, fmt.Errorf("$%x doesn't fit in a byte", v)
+	}
+	return uint8(v), nil
+}
+
+func (a *assembler) wordValue(v int) (uint16, error) {
+	if a.pass == 2 && (v < -0x8000 || v > 0xffff) {
+		return 0, fmt.Errorf("$%x doesn't fit in a word", v)
+	}
+	return uint16(v), nil
+}
+
+// operand is an instruction's operand with the choice of addressing modes its syntax allows, in
+// order of preference. Where the first is a zero page mode it is only used if the value fits.
+type operand struct {
+	modes []string
+	exprs []string
+}
+
+// parseOperand works out which addressing modes the syntax of s allows.
+func parseOperand(s string) (operand, error) {
+	if s == "" || strings.EqualFold(s, "A") {
+		return operand{modes: []string{"imp"}}, nil
+	}
+	if strings.HasPrefix(s, "#") {
+		return operand{modes: []string{"imm"}, exprs: []string{s[1:]}}, nil
+	}
+
+	if strings.HasPrefix(s, "(") {
+		depth, end := 0, -1
+		for i := 0; i < len(s) && end < 0; i++ {
+			switch s[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		inner, rest := "", ""
+		if end >= 0 {
+			inner, rest = s[1:end], strings.TrimSpace(s[end+1:])
+		}
+		args, err := splitArgs(inner)
+		if err != nil {
+			return operand{}, err
+		}
+		switch {
+		case end < 0:
+			return operand{}, fmt.Errorf("missing ) in %q", s)
+		case rest == "" && len(args) == 2 && strings.EqualFold(args[1], "X"):
+			return operand{modes: []string{"izx", "iax"}, exprs: args[:1]}, nil
+		case rest == "" && len(args) == 1:
+			return operand{modes: []string{"izp", "ind"}, exprs: args}, nil
+		case strings.HasPrefix(rest, ",") && strings.EqualFold(strings.TrimSpace(rest[1:]), "Y") && len(args) == 1:
+			return operand{modes: []string{"izy"}, exprs: args}, nil
+		}
+		// otherwise the brackets are part of an expression
+	}
+
+	args, err := splitArgs(s)
+	if err != nil {
+		return operand{}, err
+	}
+	switch {
+	case len(args) == 1:
+		return operand{modes: []string{"rel", "zp", "abs"}, exprs: args}, nil
+	case len(args) == 2 && strings.EqualFold(args[1], "X"):
+		return operand{modes: []string{"zpx", "abx"}, exprs: args[:1]}, nil
+	case len(args) == 2 && strings.EqualFold(args[1], "Y"):
+		return operand{modes: []string{"zpy", "aby"}, exprs: args[:1]}, nil
+	case len(args) == 2:
+		return operand{modes: []string{"zpr"}, exprs: args}, nil
+	}
+	return operand{}, fmt.Errorf("bad operand %q", s)
+}
+
+// zeroPage lists the modes with a zero page address, which are only used when it fits.
+var zeroPage = map[string]bool{"zp": true, "zpx": true, "zpy": true, "izx": true, "izp": true}
+
+func (a *assembler) instruction(mne string, text string) error {
+	modes := a.opcodes(mne)
+	if modes == nil {
+		return fmt.Errorf("unknown instruction %s", strings.TrimSuffix(mne, "*"))
+	}
+	op, err := parseOperand(text)
+	if err != nil {
+		return err
+	}
+	if len(op.exprs) > 0 && len(op.exprs[0]) > 2 && strings.EqualFold(op.exprs[0][:2], "a:") {
+		op.exprs[0] = op.exprs[0][2:]
+		var modes []string
+		for _, m := range op.modes {
+			if !zeroPage[m] {
+				modes = append(modes, m)
+			}
+		}
+		op.modes = modes
+	}
+
+	values := make([]int, len(op.exprs))
+	known := true
+	for i, e := range op.exprs {
+		v, k, err := a.eval(e)
+		if err != nil {
+			return err
+		}
+		values[i], known = v, known && k
+	}
+
+	// choose the mode in the first pass so nothing moves in the second
+	mode, chosen := a.modes[a.line]
+	if !chosen {
+		var candidates []string
+		for _, m := range op.modes {
+			if _, ok := modes[m]; ok {
+				candidates = append(candidates, m)
+			}
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("%s can't be used with %q", mne, text)
+		}
+		mode = candidates[0]
+		if len(candidates) > 1 && zeroPage[mode] && (!known || values[0] < 0 || values[0] > 0xff) {
+			mode = candidates[1]
+		}
+		a.modes[a.line] = mode
+	}
+
+	in := modes[mode]
+	bytes := []uint8{in.Opcode}
+	switch mode {
+	case "imp":
+	case "imm":
+		b, err := a.byteValue(values[0])
+		if err != nil {
+			return err
+		}
+		bytes = append(bytes, b)
+	case "rel":
+		offset, err := a.branchOffset(values[0], a.pc+2)
+		if err != nil {
+			return err
+		}
+		bytes = append(bytes, offset)
+	case "zpr":
+		zp, err := a.zeroPageValue(values[0])
+		if err != nil {
+			return err
+		}
+		offset, err := a.branchOffset(values[1], a.pc+3)
+		if err != nil {
+			return err
+		}
+		bytes = append(bytes, zp, offset)
+	default:
+		if in.Size == 2 {
+			zp, err := a.zeroPageValue(values[0])
+			if err != nil {
+				return err
+			}
+			bytes = append(bytes, zp)
+		} else {
+			w, err := a.wordValue(values[0])
+			if err != nil {
+				return err
+			}
+			bytes = append(bytes, uint8(w), uint8(w>>8))
+		}
+	}
+	a.emit(bytes...)
+	return nil
+}
+
+func (a *assembler) zeroPageValue(v int) (uint8, error) {
+	if a.pass == 2 && (v < 0 || v > 0xff) {
+		return 0, fmt.Errorf("$%x is not a zero page address", v)
+	}
+	return uint8(v), nil
+}
+
+// branchOffset gives the offset from next, the address after the branch, to target.
+func (a *assembler) branchOffset(target int, next uint16) (uint8, error) {
+	offset := target - int(next)
+	if a.pass == 2 && (offset < -0x80 || offset > 0x7f) {
+		return 0, fmt.Errorf("branch to $%04x is out of range", target)
+	}
+	return uint8(offset), nil
+}
@@ -0,0 +1,217 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/noddy76/munch"
+)
+
+var update = flag.Bool("update", false, "rewrite the decimal test binaries from their source")
+
+// TestDisassemblyRoundTrip assembles the disassembly of every opcode and checks it disassembles
+// back to the same thing.
+func TestDisassemblyRoundTrip(t *testing.T) {
+	for _, variant := range []munch.Variant{munch.NMOS6502, munch.WDC65C02} {
+		bus := munch.NewBus()
+		bus.Addressable(0x0000, 0xffff, munch.NewRam(0x10000))
+		cpu := munch.NewCpu6502(bus)
+		if variant == munch.WDC65C02 {
+			cpu = munch.NewCpu65C02(bus)
+		}
+
+		for _, in := range munch.Instructions(variant) {
+			bus.Poke(0x0400, in.Opcode)
+			bus.Poke(0x0401, 0x12)
+			bus.Poke(0x0402, 0x34)
+			text, size := cpu.Disassemble(0x0400)
+
+			prog, err := Assemble(".org $0400\n"+text, variant)
+			if err != nil {
+				t.Errorf("variant %d opcode $%02x %q: %v", variant, in.Opcode, text, err)
+				continue
+			}
+			bytes, _ := prog.Bytes()
+			if len(bytes) != int(size) || !reflect.DeepEqual(bytes[1:], []uint8{0x12, 0x34}[:size-1]) {
+				t.Errorf("variant %d opcode $%02x %q assembled to % x", variant, in.Opcode, text, bytes)
+				continue
+			}
+			prog.Load(bus)
+			if again, _ := cpu.Disassemble(0x0400); again != text {
+				t.Errorf("variant %d opcode $%02x %q assembled to $%02x %q", variant, in.Opcode, text, bytes[0], again)
+			}
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	src := `
+; counts down from 8 to 3
+count = 8
+        .org $0600
+start:  LDX #count      ; forward and backward references
+@loop:  DEX
+        STX result
+        CPX #3
+        BNE @loop
+        STX result+1
+        JMP done
+done:   BRK
+@loop:  .byte 1, -1, 'A', "hi;", <start, >start
+        .word start, * + 2
+result = $0200
+`
+	prog, err := Assemble(src, munch.NMOS6502)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, addr := prog.Bytes()
+	expected := []uint8{
+		0xa2, 0x08, // LDX #$08
+		0xca,             // DEX
+		0x8e, 0x00, 0x02, // STX $0200, absolute as result is defined later
+		0xe0, 0x03, // CPX #$03
+		0xd0, 0xf8, // BNE $0602
+		0x8e, 0x01, 0x02, // STX $0201
+		0x4c, 0x10, 0x06, // JMP $0610
+		0x00,                                           // BRK
+		0x01, 0xff, 0x41, 0x68, 0x69, 0x3b, 0x00, 0x06, // .byte
+		0x00, 0x06, 0x1d, 0x06, // .word
+	}
+	if addr != 0x0600 || !reflect.DeepEqual(bytes, expected) {
+		t.Errorf("assembled at $%04x\n% x\nexpected\n% x", addr, bytes, expected)
+	}
+	for name, v := range map[string]uint16{"start": 0x0600, "start@loop": 0x0602, "done": 0x0610, "done@loop": 0x0611, "count": 8} {
+		if prog.Symbols[name] != v {
+			t.Errorf("%s is $%04x, expected $%04x", name, prog.Symbols[name], v)
+		}
+	}
+
+	bus := munch.NewBus()
+	bus.Addressable(0x0000, 0xffff, munch.NewRam(0x10000))
+	cpu := munch.NewCpu6502(bus)
+	prog.Load(bus)
+	cpu.PC = 0x0600
+	for cpu.PC != 0x0610 {
+		bus.Tick()
+	}
+	if bus.Read(0x0200) != 3 || bus.Read(0x0201) != 3 {
+		t.Errorf("program stored $%02x $%02x", bus.Read(0x0200), bus.Read(0x0201))
+	}
+}
+
+func TestAddressingModes(t *testing.T) {
+	for _, tc := range []struct {
+		src      string
+		variant  munch.Variant
+		expected []uint8
+	}{
+		{"LDA $12", munch.NMOS6502, []uint8{0xa5, 0x12}},
+		{"LDA $0012", munch.NMOS6502, []uint8{0xa5, 0x12}},
+		{"LDA $1234", munch.NMOS6502, []uint8{0xad, 0x34, 0x12}},
+		{"LDA a:$12", munch.NMOS6502, []uint8{0xad, 0x12, 0x00}},
+		{"STA a:$12,X", munch.NMOS6502, []uint8{0x9d, 0x12, 0x00}},
+		{"LDA $12,x", munch.NMOS6502, []uint8{0xb5, 0x12}},
+		{"LDX $12,Y", munch.NMOS6502, []uint8{0xb6, 0x12}},
+		{"LDA $12,Y", munch.NMOS6502, []uint8{0xb9, 0x12, 0x00}},
+		{"LDA ($12,X)", munch.NMOS6502, []uint8{0xa1, 0x12}},
+		{"LDA ($12),Y", munch.NMOS6502, []uint8{0xb1, 0x12}},
+		{"LDA ($12)", munch.WDC65C02, []uint8{0xb2, 0x12}},
+		{"LDA (1+2)*3", munch.NMOS6502, []uint8{0xa5, 0x09}},
+		{"JMP ($1234)", munch.NMOS6502, []uint8{0x6c, 0x34, 0x12}},
+		{"JMP ($1234,X)", munch.WDC65C02, []uint8{0x7c, 0x34, 0x12}},
+		{"ROR A", munch.NMOS6502, []uint8{0x6a}},
+		{"INC", munch.WDC65C02, []uint8{0x1a}},
+		{"NOP", munch.NMOS6502, []uint8{0xea}},
+		{"LAX* $12", munch.NMOS6502, []uint8{0xa7, 0x12}},
+		{"SBC #$12", munch.NMOS6502, []uint8{0xe9, 0x12}},
+		{"BBS7 $12,*", munch.WDC65C02, []uint8{0xff, 0x12, 0xfd}},
+		{"LDA #%1010 | $f0 ^ 1 << 2", munch.NMOS6502, []uint8{0xa9, 0xfe}},
+	} {
+		prog, err := Assemble(tc.src, tc.variant)
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		if bytes, _ := prog.Bytes(); !reflect.DeepEqual(bytes, tc.expected) {
+			t.Errorf("%q assembled to % x, expected % x", tc.src, bytes, tc.expected)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		line int
+		msg  string
+	}{
+		{"LDA missing", 1, "undefined symbol missing"},
+		{"\n\nFOO #1", 3, "unknown instruction FOO"},
+		{".org $0400\nBNE far\n.org $0500\nfar: RTS", 2, "out of range"},
+		{"LDA ($1234,X)", 1, "not a zero page address"},
+		{"x: NOP\nx: NOP", 2, "defined twice"},
+		{"LDA #$100", 1, "doesn't fit in a byte"},
+		{"STA #1", 1, "can't be used"},
+	} {
+		_, err := Assemble(tc.src, munch.NMOS6502)
+		var asmErr *Error
+		if !errors.As(err, &asmErr) || asmErr.Line != tc.line || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%q gave %v, expected %q on line %d", tc.src, err, tc.msg, tc.line)
+		}
+	}
+}
+
+// TestDecimalTestSource checks the decimal test binaries in functional_tests were assembled from
+// 6502_decimal_test.ca65, or with -update writes them.
+func TestDecimalTestSource(t *testing.T) {
+	src, err := os.ReadFile("../functional_tests/6502_decimal_test.ca65")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		file    string
+		cputype int
+		variant munch.Variant
+	}{
+		{"6502_decimal_test.bin", 0, munch.NMOS6502},
+		{"65c02_decimal_test.bin", 1, munch.WDC65C02},
+	} {
+		config := fmt.Sprintf("cputype = %d\nchecks = $c3\n", tc.cputype)
+		prog, err := Assemble(config+string(src), tc.variant)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		bin, _ := prog.Bytes()
+		path := "../functional_tests/" + tc.file
+		if *update {
+			if err := os.WriteFile(path, bin, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if dat, err := os.ReadFile(path); err != nil || !bytes.Equal(dat, bin) {
+			t.Errorf("%s doesn't match the source, run with -update to rebuild it", tc.file)
+		}
+	}
+}
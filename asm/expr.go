@@ -0,0 +1,242 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprParser evaluates an expression. Symbols that aren't defined yet make the value unknown
+// rather than failing, so forward references work in the first pass.
+type exprParser struct {
+	a     *assembler
+	s     string
+	pos   int
+	known bool
+}
+
+// eval evaluates s, returning the value and whether every symbol in it was defined.
+func (a *assembler) eval(s string) (int, bool, error) {
+	p := &exprParser{a: a, s: s, known: true}
+	v, err := p.or()
+	if err != nil {
+		return 0, false, err
+	}
+	p.space()
+	if p.pos != len(p.s) {
+		return 0, false, fmt.Errorf("unexpected %q in expression %q", p.s[p.pos:], s)
+	}
+	return v, p.known, nil
+}
+
+func (p *exprParser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept consumes op if it comes next, but not if it is the start of a longer operator.
+func (p *exprParser) accept(op string, not ...string) bool {
+	p.space()
+	rest := p.s[p.pos:]
+	if !strings.HasPrefix(rest, op) {
+		return false
+	}
+	for _, n := range not {
+		if strings.HasPrefix(rest, n) {
+			return false
+		}
+	}
+	p.pos += len(op)
+	return true
+}
+
+func (p *exprParser) or() (int, error) {
+	v, err := p.xor()
+	for err == nil && p.accept("|") {
+		var r int
+		r, err = p.xor()
+		v |= r
+	}
+	return v, err
+}
+
+func (p *exprParser) xor() (int, error) {
+	v, err := p.and()
+	for err == nil && p.accept("^") {
+		var r int
+		r, err = p.and()
+		v ^= r
+	}
+	return v, err
+}
+
+func (p *exprParser) and() (int, error) {
+	v, err := p.shift()
+	for err == nil && p.accept("&") {
+		var r int
+		r, err = p.shift()
+		v &= r
+	}
+	return v, err
+}
+
+func (p *exprParser) shift() (int, error) {
+	v, err := p.add()
+	for err == nil {
+		var r int
+		if p.accept("<<") {
+			r, err = p.add()
+			v <<= uint(r)
+		} else if p.accept(">>") {
+			r, err = p.add()
+			v >>= uint(r)
+		} else {
+			break
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) add() (int, error) {
+	v, err := p.mul()
+	for err == nil {
+		var r int
+		if p.accept("+") {
+			r, err = p.mul()
+			v += r
+		} else if p.accept("-") {
+			r, err = p.mul()
+			v -= r
+		} else {
+			break
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) mul() (int, error) {
+	v, err := p.unary()
+	for err == nil {
+		var r int
+		if p.accept("*") {
+			r, err = p.unary()
+			v *= r
+		} else if p.accept("/") {
+			r, err = p.unary()
+			if err == nil && r == 0 {
+				if p.known {
+					return 0, fmt.Errorf("division by zero in %q", p.s)
+				}
+				r = 1
+			}
+			v /= r
+		} else {
+			break
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) unary() (int, error) {
+	switch {
+	case p.accept("-"):
+		v, err := p.unary()
+		return -v, err
+	case p.accept("~"):
+		v, err := p.unary()
+		return ^v, err
+	case p.accept("<"):
+		v, err := p.unary()
+		return v & 0xff, err
+	case p.accept(">"):
+		v, err := p.unary()
+		return v >> 8 & 0xff, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int, error) {
+	p.space()
+	if p.pos == len(p.s) {
+		return 0, fmt.Errorf("missing value in expression %q", p.s)
+	}
+	c := p.s[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		v, err := p.or()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(")") {
+			return 0, fmt.Errorf("missing ) in expression %q", p.s)
+		}
+		return v, nil
+	case c == '*':
+		p.pos++
+		return int(p.a.pc), nil
+	case c == '\'':
+		if p.pos+2 >= len(p.s) || p.s[p.pos+2] != '\'' {
+			return 0, fmt.Errorf("bad character constant in %q", p.s)
+		}
+		v := int(p.s[p.pos+1])
+		p.pos += 3
+		return v, nil
+	case c == '$':
+		return p.number(16, 1)
+	case c == '%':
+		return p.number(2, 1)
+	case c >= '0' && c <= '9':
+		return p.number(10, 0)
+	case isIdentStart(c) || c == '@':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.s) && isIdent(p.s[p.pos]) {
+			p.pos++
+		}
+		v, ok, err := p.a.lookup(p.s[start:p.pos])
+		if !ok {
+			p.known = false
+		}
+		return v, err
+	}
+	return 0, fmt.Errorf("unexpected %q in expression %q", p.s[p.pos:], p.s)
+}
+
+func (p *exprParser) number(base int, prefix int) (int, error) {
+	start := p.pos + prefix
+	end := start
+	for end < len(p.s) && isIdent(p.s[end]) {
+		end++
+	}
+	v, err := strconv.ParseInt(p.s[start:end], base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", p.s[p.pos:end])
+	}
+	p.pos = end
+	return int(v), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
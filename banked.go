@@ -0,0 +1,103 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// banks holds the memory behind a banked device and which bank is visible through its window.
+type banks struct {
+	bytes  []uint8
+	size   int
+	bank   int
+	offset int
+}
+
+// SelectBank makes bank n visible. Like a mapper register that ignores unused bits, n wraps
+// around the number of banks.
+func (b *banks) SelectBank(n int) {
+	count := b.Banks()
+	n %= count
+	if n < 0 {
+		n += count
+	}
+	b.bank = n
+	b.offset = n * b.size
+}
+
+func (b *banks) Bank() int { return b.bank }
+
+func (b *banks) Banks() int { return len(b.bytes) / b.size }
+
+func (b *banks) index(addr uint16) int {
+	return b.offset + int(addr)%b.size
+}
+
+// BankedRom is a ROM split into banks of bankSize bytes with one bank visible at a time.
+type BankedRom struct {
+	banks
+}
+
+// NewBankedRom copies bytes into a new banked ROM. If bytes is not a whole number of banks the
+// last bank is padded with zeros.
+func NewBankedRom(bytes []uint8, bankSize uint) *BankedRom {
+	if bankSize == 0 || bankSize > 0x10000 {
+		bankSize = 0x10000
+	}
+	count := (uint(len(bytes)) + bankSize - 1) / bankSize
+	if count == 0 {
+		count = 1
+	}
+	b := make([]uint8, count*bankSize)
+	copy(b, bytes)
+	return &BankedRom{banks{bytes: b, size: int(bankSize)}}
+}
+
+func (r *BankedRom) Read(addr uint16) uint8 {
+	return r.bytes[r.index(addr)]
+}
+
+func (r *BankedRom) Write(addr uint16, v uint8) {}
+
+func (r *BankedRom) Peek(addr uint16) uint8 { return r.Read(addr) }
+
+// Poke patches the selected bank.
+func (r *BankedRom) Poke(addr uint16, v uint8) {
+	r.bytes[r.index(addr)] = v
+}
+
+// BankedRam is count banks of bankSize bytes of RAM with one bank visible at a time.
+type BankedRam struct {
+	banks
+}
+
+func NewBankedRam(count uint, bankSize uint) *BankedRam {
+	if bankSize == 0 || bankSize > 0x10000 {
+		bankSize = 0x10000
+	}
+	if count == 0 {
+		count = 1
+	}
+	return &BankedRam{banks{bytes: make([]uint8, count*bankSize), size: int(bankSize)}}
+}
+
+func (r *BankedRam) Read(addr uint16) uint8 {
+	return r.bytes[r.index(addr)]
+}
+
+func (r *BankedRam) Write(addr uint16, v uint8) {
+	r.bytes[r.index(addr)] = v
+}
+
+func (r *BankedRam) Peek(addr uint16) uint8    { return r.Read(addr) }
+func (r *BankedRam) Poke(addr uint16, v uint8) { r.Write(addr, v) }
@@ -24,28 +24,108 @@ type Addressable interface {
 	Write(addr uint16, v uint8)
 }
 
-type memoryDevice struct {
+// Peeker is implemented by Addressables that can be read without side effects, such as I/O chips
+// that clear flags or advance a FIFO when read. Debuggers and disassemblers read through Peek.
+type Peeker interface {
+	Peek(addr uint16) uint8
+}
+
+// Poker is implemented by Addressables that can be written without side effects, and may allow
+// writing memory the CPU can't, such as patching a ROM.
+type Poker interface {
+	Poke(addr uint16, v uint8)
+}
+
+// Mapping is a device mapped into the address space, returned by the Bus so the mapping can be
+// changed while running. It responds to addresses between start and end that also give match
+// when ANDed with mask. The device sees addresses relative to start, or just the address lines
+// not in mask for masked mappings, modulo mirror if that is not zero.
+type Mapping struct {
+	bus      *Bus
+	priority int
+
 	start  uint16
 	end    uint16
+	mask   uint16
+	match  uint16
+	mirror uint16
 	device Addressable
 }
 
+func (m *Mapping) contains(addr uint16) bool {
+	return addr >= m.start && addr <= m.end && addr&m.mask == m.match
+}
+
+func (m *Mapping) offset(addr uint16) uint16 {
+	var o uint16
+	if m.mask != 0 {
+		o = addr &^ m.mask
+	} else {
+		o = addr - m.start
+	}
+	if m.mirror != 0 {
+		o %= m.mirror
+	}
+	return o
+}
+
+// overlaps reports whether the device responds to any address in the page starting at first.
+func (m *Mapping) overlaps(first uint16) bool {
+	return m.start <= first|0xff && m.end >= first && first&m.mask&0xff00 == m.match&0xff00
+}
+
+// covers reports whether the device responds to every address in the page starting at first.
+func (m *Mapping) covers(first uint16) bool {
+	return m.start <= first && m.end >= first|0xff && m.mask&0x00ff == 0 && first&m.mask == m.match
+}
+
+// page is an entry in the bus's page table. If a single device covers the whole page it is in
+// device, otherwise devices holds every device overlapping the page in priority order.
+type page struct {
+	device  *Mapping
+	devices []*Mapping
+}
+
 type Bus struct {
 	tickCount uint64
 
-	tickers []Ticker
-	mems    []memoryDevice
+	tickers []*TickerHandle
+	mems    []*Mapping
+	pages   [0x100]page
+	watches []*Watch
+}
+
+// Watch calls Func for every read or write the bus makes between Start and End inclusive. Peek and
+// Poke are not watched.
+type Watch struct {
+	Start uint16
+	End   uint16
+	Read  bool
+	Write bool
+	Func  func(addr uint16, v uint8, write bool)
+
+	bus *Bus
 }
 
+// TickerHandle is a ticker added to the bus, Remove takes it off again.
+type TickerHandle struct {
+	ticker Ticker
+	bus    *Bus
+}
+
+var unmapped = &Mapping{start: 0, end: 0xffff, device: &NullDevice{}}
+
 func NewBus() *Bus {
-	return &Bus{tickers: make([]Ticker, 0)}
+	b := &Bus{tickers: make([]*TickerHandle, 0)}
+	b.buildPages()
+	return b
 }
 
 // One tick of the clock
 func (b *Bus) Tick() error {
 	b.tickCount++
 	for _, t := range b.tickers {
-		if err := t.Tick(); err != nil {
+		if err := t.ticker.Tick(); err != nil {
 			return err
 		}
 	}
@@ -54,34 +134,235 @@ func (b *Bus) Tick() error {
 
 func (b *Bus) TickCount() uint64 { return b.tickCount }
 
-func (b *Bus) Addressable(start uint16, end uint16, device Addressable) {
-	b.mems = append(b.mems, memoryDevice{start: start, end: end, device: device})
+// Addressable maps device into the address space from start to end inclusive. Where mappings
+// overlap the one with the highest priority wins, then the one added first.
+func (b *Bus) Addressable(start uint16, end uint16, device Addressable) *Mapping {
+	return b.mapDevice(&Mapping{start: start, end: end, device: device})
+}
+
+// AddressableMirrored maps device from start to end inclusive, repeating it every size bytes. For
+// example the 2KB of RAM in the NES, which appears four times from $0000 to $1fff, would be mapped
+// with AddressableMirrored(0x0000, 0x1fff, 0x0800, ram).
+func (b *Bus) AddressableMirrored(start uint16, end uint16, size uint16, device Addressable) *Mapping {
+	return b.mapDevice(&Mapping{start: start, end: end, mirror: size, device: device})
+}
+
+// AddressableMasked maps device at every address that gives match when ANDed with mask, like
+// hardware that only decodes some of the address lines. The device is passed the address lines
+// that are not in mask, modulo size if size is not zero. For example an I/O chip with 16
+// registers decoded by the top ten address lines at $1700 would be mapped with
+// AddressableMasked(0xffc0, 0x1700, 16, chip) and appear four times from $1700 to $173f.
+func (b *Bus) AddressableMasked(mask uint16, match uint16, size uint16, device Addressable) *Mapping {
+	return b.mapDevice(&Mapping{
+		start:  0x0000,
+		end:    0xffff,
+		mask:   mask,
+		match:  match & mask,
+		mirror: size,
+		device: device,
+	})
+}
+
+func (b *Bus) mapDevice(mem *Mapping) *Mapping {
+	mem.bus = b
+	b.insert(mem)
+	b.buildPages()
+	return mem
+}
+
+// insert adds mem to mems after every mapping with the same or higher priority.
+func (b *Bus) insert(mem *Mapping) {
+	i := len(b.mems)
+	for i > 0 && b.mems[i-1].priority < mem.priority {
+		i--
+	}
+	b.mems = append(b.mems, nil)
+	copy(b.mems[i+1:], b.mems[i:])
+	b.mems[i] = mem
+}
+
+// remove takes mem out of mems, returning false if it was not there.
+func (b *Bus) remove(mem *Mapping) bool {
+	for i, m := range b.mems {
+		if m == mem {
+			b.mems = append(b.mems[:i], b.mems[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Unmap removes the mapping from the bus. Addresses it covered fall through to any lower priority
+// mapping underneath.
+func (m *Mapping) Unmap() {
+	if m.bus != nil && m.bus.remove(m) {
+		m.bus.buildPages()
+	}
+}
+
+// SetDevice swaps the device behind the mapping. This is cheap enough to do on every access if a
+// mapper needs to.
+func (m *Mapping) SetDevice(device Addressable) {
+	m.device = device
+}
+
+func (m *Mapping) Device() Addressable { return m.device }
+
+// SetPriority changes which mapping wins where mappings overlap. Higher priorities win, mappings
+// are added with priority 0.
+func (m *Mapping) SetPriority(priority int) {
+	if m.bus == nil || !m.bus.remove(m) {
+		m.priority = priority
+		return
+	}
+	m.priority = priority
+	m.bus.insert(m)
+	m.bus.buildPages()
+}
+
+func (m *Mapping) Priority() int { return m.priority }
+
+// buildPages rebuilds the page table used to find the device mapped at an address.
+func (b *Bus) buildPages() {
+	for i := range b.pages {
+		first := uint16(i) << 8
+		p := page{}
+		for _, mem := range b.mems {
+			if !mem.overlaps(first) {
+				continue
+			}
+			if mem.covers(first) {
+				if len(p.devices) == 0 {
+					p.device = mem
+					break
+				}
+				p.devices = append(p.devices, mem)
+				break
+			}
+			p.devices = append(p.devices, mem)
+		}
+		if p.device == nil && len(p.devices) == 0 {
+			p.device = unmapped
+		}
+		b.pages[i] = p
+	}
+}
+
+// Ticker adds t to the tickers called, in the order they were added, on every tick of the bus.
+func (b *Bus) Ticker(t Ticker) *TickerHandle {
+	h := &TickerHandle{ticker: t, bus: b}
+	b.tickers = append(b.tickers, h)
+	return h
 }
 
-func (b *Bus) Ticker(t Ticker) {
-	b.tickers = append(b.tickers, t)
+// Remove stops the ticker being called. It is safe to call from the ticker's own Tick.
+func (h *TickerHandle) Remove() {
+	if h.bus == nil {
+		return
+	}
+	b := h.bus
+	for i, o := range b.tickers {
+		if o == h {
+			// copied so a Tick loop ranging over the old slice is not disturbed
+			tickers := make([]*TickerHandle, 0, len(b.tickers)-1)
+			tickers = append(tickers, b.tickers[:i]...)
+			b.tickers = append(tickers, b.tickers[i+1:]...)
+			break
+		}
+	}
+	h.bus = nil
 }
 
 func (b *Bus) Read(addr uint16) uint8 {
 	d := b.findDevice(addr)
-	return d.device.Read(addr - d.start)
+	v := d.device.Read(d.offset(addr))
+	if b.watches != nil {
+		b.notify(addr, v, false)
+	}
+	return v
 }
 
 func (b *Bus) Write(addr uint16, v uint8) {
 	d := b.findDevice(addr)
-	d.device.Write(addr-d.start, v)
+	d.device.Write(d.offset(addr), v)
+	if b.watches != nil {
+		b.notify(addr, v, true)
+	}
 }
 
-func (b *Bus) findDevice(addr uint16) memoryDevice {
-	for _, mem := range b.mems {
-		if addr >= mem.start && addr <= mem.end {
+// Watch calls fn for reads, writes or both between start and end inclusive.
+func (b *Bus) Watch(start uint16, end uint16, read bool, write bool, fn func(addr uint16, v uint8, write bool)) *Watch {
+	w := &Watch{Start: start, End: end, Read: read, Write: write, Func: fn, bus: b}
+	b.watches = append(b.watches, w)
+	return w
+}
+
+// Remove stops the watch. It is safe to call from the Func of any watch.
+func (w *Watch) Remove() {
+	if w.bus == nil {
+		return
+	}
+	b := w.bus
+	for i, o := range b.watches {
+		if o == w {
+			// copied so a notify ranging over the old slice is not disturbed
+			var watches []*Watch
+			if len(b.watches) > 1 {
+				watches = make([]*Watch, 0, len(b.watches)-1)
+				watches = append(watches, b.watches[:i]...)
+				watches = append(watches, b.watches[i+1:]...)
+			}
+			b.watches = watches
+			break
+		}
+	}
+	w.bus = nil
+}
+
+func (b *Bus) notify(addr uint16, v uint8, write bool) {
+	for _, w := range b.watches {
+		if w.bus == nil || addr < w.Start || addr > w.End || (write && !w.Write) || (!write && !w.Read) {
+			continue
+		}
+		w.Func(addr, v, write)
+	}
+}
+
+// Peek reads addr without side effects. Devices that don't implement Peeker are read normally.
+func (b *Bus) Peek(addr uint16) uint8 {
+	d := b.findDevice(addr)
+	if p, ok := d.device.(Peeker); ok {
+		return p.Peek(d.offset(addr))
+	}
+	return d.device.Read(d.offset(addr))
+}
+
+// Poke writes addr without side effects. Devices that don't implement Poker are written normally.
+func (b *Bus) Poke(addr uint16, v uint8) {
+	d := b.findDevice(addr)
+	if p, ok := d.device.(Poker); ok {
+		p.Poke(d.offset(addr), v)
+		return
+	}
+	d.device.Write(d.offset(addr), v)
+}
+
+func (b *Bus) findDevice(addr uint16) *Mapping {
+	p := &b.pages[addr>>8]
+	if p.device != nil {
+		return p.device
+	}
+	for _, mem := range p.devices {
+		if mem.contains(addr) {
 			return mem
 		}
 	}
-	return memoryDevice{start: 0, end: 0xffff, device: &NullDevice{}}
+	return unmapped
 }
 
 type NullDevice struct{}
 
 func (*NullDevice) Read(uint16) uint8   { return 0 }
 func (*NullDevice) Write(uint16, uint8) {}
+func (*NullDevice) Peek(uint16) uint8   { return 0 }
+func (*NullDevice) Poke(uint16, uint8)  {}
@@ -0,0 +1,356 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"os"
+	"testing"
+)
+
+type constDevice uint8
+
+func (c constDevice) Read(uint16) uint8   { return uint8(c) }
+func (c constDevice) Write(uint16, uint8) {}
+
+func TestBusMapping(t *testing.T) {
+	bus := NewBus()
+	bus.Addressable(0x1010, 0x101f, constDevice(1))
+	bus.Addressable(0x1000, 0x1fff, constDevice(2))
+	bus.Addressable(0x1100, 0x11ff, constDevice(3))
+	bus.Addressable(0x2000, 0x20ff, NewRam(0x100))
+
+	for _, tc := range []struct {
+		addr uint16
+		v    uint8
+	}{
+		{0x0fff, 0},
+		{0x1000, 2},
+		{0x100f, 2},
+		{0x1010, 1},
+		{0x101f, 1},
+		{0x1020, 2},
+		{0x1100, 2},
+		{0x1fff, 2},
+		{0x2100, 0},
+	} {
+		if v := bus.Read(tc.addr); v != tc.v {
+			t.Errorf("read $%02x from $%04x, expected $%02x", v, tc.addr, tc.v)
+		}
+	}
+
+	bus.Write(0x2042, 0x42)
+	if v := bus.Read(0x2042); v != 0x42 {
+		t.Errorf("read $%02x back from RAM", v)
+	}
+}
+
+// linearBus finds devices the way Bus did before it had a page table.
+type linearBus struct {
+	mems []Mapping
+}
+
+func (b *linearBus) Addressable(start uint16, end uint16, device Addressable) {
+	b.mems = append(b.mems, Mapping{start: start, end: end, device: device})
+}
+
+func (b *linearBus) findDevice(addr uint16) Mapping {
+	for _, mem := range b.mems {
+		if addr >= mem.start && addr <= mem.end {
+			return mem
+		}
+	}
+	return *unmapped
+}
+
+func (b *linearBus) Read(addr uint16) uint8 {
+	d := b.findDevice(addr)
+	return d.device.Read(addr - d.start)
+}
+
+func (b *linearBus) Write(addr uint16, v uint8) {
+	d := b.findDevice(addr)
+	d.device.Write(addr-d.start, v)
+}
+
+// BenchmarkFunctionalTest runs the functional test on a machine with sixteen devices mapped, with
+// RAM last so every access has to get past the others.
+func BenchmarkFunctionalTest(b *testing.B) {
+	dat, err := os.ReadFile("functional_tests/6502_functional_test.bin")
+	if err != nil {
+		b.Fatal("Unable to open test binary")
+	}
+
+	setup := func(addressable func(start uint16, end uint16, device Addressable)) {
+		for i := uint16(0); i < 15; i++ {
+			addressable(0xf000+i*0x10, 0xf00f+i*0x10, NewRam(0x10))
+		}
+		addressable(0x0000, 0xffff, NewRam(0x10000))
+	}
+
+	for _, bc := range []struct {
+		name  string
+		setup func(*Bus)
+	}{
+		{"page table", func(bus *Bus) {
+			setup(func(start uint16, end uint16, device Addressable) { bus.Addressable(start, end, device) })
+		}},
+		{"linear scan", func(bus *Bus) {
+			lb := &linearBus{}
+			setup(lb.Addressable)
+			bus.Addressable(0x0000, 0xffff, lb)
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bus := NewBus()
+				bc.setup(bus)
+				cpu := NewCpu6502(bus)
+				for a, v := range dat {
+					bus.Write(uint16(a), v)
+				}
+				cpu.PC = 0x0400
+				for cpu.PC != 0x3469 {
+					bus.Tick()
+				}
+			}
+		})
+	}
+}
+
+// registers records the offsets it is accessed at
+type registers struct {
+	offsets []uint16
+}
+
+func (r *registers) Read(addr uint16) uint8 {
+	r.offsets = append(r.offsets, addr)
+	return uint8(addr)
+}
+
+func (r *registers) Write(addr uint16, v uint8) {
+	r.offsets = append(r.offsets, addr)
+}
+
+func TestBusMirrored(t *testing.T) {
+	bus := NewBus()
+	ram := NewRam(0x0800)
+	bus.AddressableMirrored(0x0000, 0x1fff, 0x0800, ram)
+
+	bus.Write(0x0012, 0x34)
+	for _, addr := range []uint16{0x0012, 0x0812, 0x1012, 0x1812} {
+		if v := bus.Read(addr); v != 0x34 {
+			t.Errorf("read $%02x from $%04x", v, addr)
+		}
+	}
+	if v := bus.Read(0x2012); v != 0 {
+		t.Errorf("read $%02x outside the mirrored range", v)
+	}
+}
+
+func TestBusMasked(t *testing.T) {
+	bus := NewBus()
+	io := &registers{}
+	bus.AddressableMasked(0xffc0, 0x1700, 16, io)
+	bus.Addressable(0x0000, 0xffff, constDevice(0xff))
+
+	for _, addr := range []uint16{0x1700, 0x1713, 0x172f, 0x173f} {
+		bus.Read(addr)
+	}
+	expected := []uint16{0x00, 0x03, 0x0f, 0x0f}
+	if len(io.offsets) != len(expected) {
+		t.Fatalf("device saw %v, expected %v", io.offsets, expected)
+	}
+	for i := range expected {
+		if io.offsets[i] != expected[i] {
+			t.Errorf("device saw %v, expected %v", io.offsets, expected)
+			break
+		}
+	}
+
+	for _, addr := range []uint16{0x16ff, 0x1740, 0x1f00} {
+		if v := bus.Read(addr); v != 0xff {
+			t.Errorf("masked device responded at $%04x", addr)
+		}
+	}
+}
+
+func TestBusMappingHandles(t *testing.T) {
+	bus := NewBus()
+	low := bus.Addressable(0x0000, 0xffff, constDevice(0x01))
+	high := bus.Addressable(0x8000, 0x80ff, constDevice(0x02))
+
+	if v := bus.Read(0x8000); v != 0x01 {
+		t.Errorf("read $%02x, expected the first mapping", v)
+	}
+	high.SetPriority(1)
+	if v := bus.Read(0x8000); v != 0x02 {
+		t.Errorf("read $%02x, expected the higher priority mapping", v)
+	}
+	high.SetDevice(constDevice(0x03))
+	if v := bus.Read(0x8000); v != 0x03 {
+		t.Errorf("read $%02x, expected the new device", v)
+	}
+	high.Unmap()
+	if v := bus.Read(0x8000); v != 0x01 {
+		t.Errorf("read $%02x after unmapping", v)
+	}
+	low.Unmap()
+	if v := bus.Read(0x8000); v != 0x00 {
+		t.Errorf("read $%02x with nothing mapped", v)
+	}
+}
+
+func TestBankedRom(t *testing.T) {
+	bytes := make([]uint8, 0x3000)
+	for i := range bytes {
+		bytes[i] = uint8(i >> 12)
+	}
+	rom := NewBankedRom(bytes, 0x1000)
+	bus := NewBus()
+	bus.Addressable(0xc000, 0xcfff, rom)
+
+	if rom.Banks() != 3 {
+		t.Fatalf("%d banks, expected 3", rom.Banks())
+	}
+	for _, bank := range []int{0, 2, 1, 4} {
+		rom.SelectBank(bank)
+		if v := bus.Read(0xc123); v != uint8(bank%3) {
+			t.Errorf("bank %d read $%02x", bank, v)
+		}
+	}
+}
+
+func TestBankedRam(t *testing.T) {
+	ram := NewBankedRam(4, 0x2000)
+	bus := NewBus()
+	bus.Addressable(0x6000, 0x7fff, ram)
+
+	for bank := 0; bank < 4; bank++ {
+		ram.SelectBank(bank)
+		bus.Write(0x6010, uint8(bank)+0x10)
+	}
+	for bank := 0; bank < 4; bank++ {
+		ram.SelectBank(bank)
+		if v := bus.Read(0x6010); v != uint8(bank)+0x10 {
+			t.Errorf("bank %d read $%02x", bank, v)
+		}
+	}
+}
+
+// fifo counts reads, like a device whose reads have side effects
+type fifo struct {
+	reads int
+}
+
+func (f *fifo) Read(addr uint16) uint8 {
+	f.reads++
+	return 0xea
+}
+
+func (f *fifo) Write(addr uint16, v uint8) {}
+
+func (f *fifo) Peek(addr uint16) uint8 { return 0xea }
+
+func TestBusPeekPoke(t *testing.T) {
+	bus := NewBus()
+	f := &fifo{}
+	rom := NewRom([]uint8{0x00, 0x00})
+	plain := &registers{}
+	bus.Addressable(0x4000, 0x4000, f)
+	bus.Addressable(0x8000, 0x8001, rom)
+	bus.Addressable(0x9000, 0x900f, plain)
+
+	if v := bus.Peek(0x4000); v != 0xea || f.reads != 0 {
+		t.Errorf("peek read $%02x with %d reads", v, f.reads)
+	}
+	bus.Poke(0x8001, 0x42)
+	bus.Write(0x8000, 0x42)
+	if bus.Read(0x8000) != 0x00 || bus.Read(0x8001) != 0x42 {
+		t.Errorf("poke didn't patch rom")
+	}
+	bus.Peek(0x9003)
+	if len(plain.offsets) != 1 || plain.offsets[0] != 3 {
+		t.Errorf("peek didn't fall back to read, saw %v", plain.offsets)
+	}
+
+	cpu := NewCpu6502(bus)
+	cpu.Disassemble(0x4000)
+	if f.reads != 0 {
+		t.Errorf("disassemble read the device %d times", f.reads)
+	}
+}
+
+type countingTicker struct {
+	ticks  int
+	remove *TickerHandle
+}
+
+func (c *countingTicker) Tick() error {
+	c.ticks++
+	if c.remove != nil {
+		c.remove.Remove()
+	}
+	return nil
+}
+
+func TestBusTickerRemove(t *testing.T) {
+	bus := NewBus()
+	first, second, third := &countingTicker{}, &countingTicker{}, &countingTicker{}
+	bus.Ticker(first)
+	first.remove = bus.Ticker(second)
+	bus.Ticker(third)
+
+	bus.Tick()
+	bus.Tick()
+	if first.ticks != 2 || second.ticks != 1 || third.ticks != 2 {
+		t.Errorf("ticked %d, %d and %d times", first.ticks, second.ticks, third.ticks)
+	}
+	first.remove.Remove()
+	if len(bus.tickers) != 2 {
+		t.Errorf("%d tickers left", len(bus.tickers))
+	}
+}
+
+func TestBusWatchRemove(t *testing.T) {
+	bus := NewBus()
+	bus.Addressable(0x0000, 0xffff, NewRam(0x10000))
+	var calls [4]int
+	var watches [4]*Watch
+	for i := range watches {
+		i := i
+		watches[i] = bus.Watch(0x0000, 0xffff, true, true, func(uint16, uint8, bool) {
+			calls[i]++
+			switch i {
+			case 0:
+				watches[0].Remove() // removes itself
+			case 1:
+				watches[2].Remove() // removes a later watch
+			}
+		})
+	}
+
+	bus.Read(0x1234)
+	if calls != [4]int{1, 1, 0, 1} {
+		t.Errorf("first read made calls %v", calls)
+	}
+	bus.Write(0x1234, 0x56)
+	if calls != [4]int{1, 2, 0, 2} {
+		t.Errorf("second access made calls %v", calls)
+	}
+	if len(bus.watches) != 2 {
+		t.Errorf("%d watches left", len(bus.watches))
+	}
+}
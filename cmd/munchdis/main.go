@@ -0,0 +1,104 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+// Command munchdis disassembles a binary into ca65 source.
+//
+//	munchdis [-cpu 6502|65c02|6510|2a03] [-org addr] [-entry addr,...] [-unofficial] [-o file] file
+//
+// The file is loaded at -org, or so that it ends at $ffff like a ROM. Code is found by following
+// it from each -entry address and from the vectors at $fffa-$ffff, and everything else is written
+// as data. Unofficial opcodes are taken to be data unless -unofficial is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/noddy76/munch"
+	"github.com/noddy76/munch/disasm"
+)
+
+func main() {
+	cpuType := flag.String("cpu", "6502", "processor: 6502, 65c02, 6510 or 2a03")
+	org := flag.String("org", "", "load address in hex, instead of ending at $ffff")
+	entry := flag.String("entry", "", "comma separated entry points in hex")
+	unofficial := flag.Bool("unofficial", false, "follow code through unofficial opcodes")
+	output := flag.String("o", "", "file to write, instead of stdout")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: munchdis [flags] file")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	image, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(image) > 0x10000 {
+		fatalf("%s is bigger than 64KB", flag.Arg(0))
+	}
+
+	opts := disasm.Options{Unofficial: *unofficial}
+	switch strings.ToLower(*cpuType) {
+	case "6502":
+		opts.Variant = munch.NMOS6502
+	case "65c02":
+		opts.Variant = munch.WDC65C02
+	case "6510":
+		opts.Variant = munch.MOS6510
+	case "2a03":
+		opts.Variant = munch.RP2A03
+	default:
+		fatalf("unknown cpu %q", *cpuType)
+	}
+
+	origin := uint16(0x10000 - len(image))
+	if *org != "" {
+		origin = parseAddr(*org)
+	}
+	if *entry != "" {
+		for _, s := range strings.Split(*entry, ",") {
+			opts.Entries = append(opts.Entries, parseAddr(s))
+		}
+	}
+
+	src, err := disasm.Disassemble(image, origin, opts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	src = fmt.Sprintf("; disassembled from %s\n", flag.Arg(0)) + src
+	if *output == "" {
+		fmt.Print(src)
+	} else if err := os.WriteFile(*output, []byte(src), 0o644); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func parseAddr(s string) uint16 {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(s), "$"), 16, 16)
+	if err != nil {
+		fatalf("bad address %q", s)
+	}
+	return uint16(v)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "munchdis: "+format+"\n", args...)
+	os.Exit(1)
+}
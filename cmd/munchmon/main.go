@@ -0,0 +1,141 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+// Command munchmon is a machine language monitor for a 6502 with 64KB of RAM.
+//
+//	munchmon [-cpu 6502|65c02|6510|2a03] [-pc addr] [-accurate] [-map file] [-labels file]
+//		[-dbg file] [file[@addr] ...]
+//
+// Each file is loaded before the monitor starts. Intel HEX, S-record and PRG files are recognised
+// by their extension and load where they say, starting at their entry point if they give one and
+// there is no -pc. Anything else is a raw binary loaded at its address, or $0000. Symbols from an
+// ld65 map file, VICE label file or ca65 debug info file are shown in disassembly and traces. Type
+// ? for help. While running with g, Ctrl-C stops at the end of the current instruction.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/noddy76/munch"
+	"github.com/noddy76/munch/loader"
+)
+
+func main() {
+	cpuType := flag.String("cpu", "6502", "processor: 6502, 65c02, 6510 or 2a03")
+	pc := flag.String("pc", "", "start address in hex, instead of the reset vector")
+	accurate := flag.Bool("accurate", false, "run cycle accurately")
+	mapFile := flag.String("map", "", "ld65 map file to read symbols from")
+	labelFile := flag.String("labels", "", "VICE label file to read symbols from")
+	dbgFile := flag.String("dbg", "", "ca65 debug info file to read symbols and source lines from")
+	flag.Parse()
+
+	bus := munch.NewBus()
+	ram := munch.NewRam(0x10000)
+	bus.Addressable(0x0000, 0xffff, ram)
+
+	entry := ""
+	for _, arg := range flag.Args() {
+		file, at, _ := strings.Cut(arg, "@")
+		addr := uint64(0)
+		if at != "" {
+			var err error
+			if addr, err = strconv.ParseUint(strings.TrimPrefix(at, "$"), 16, 16); err != nil {
+				fatalf("bad address in %q", arg)
+			}
+		}
+		info, err := loader.File(file, bus, uint16(addr))
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if info.HasEntry {
+			entry = fmt.Sprintf("%04x", info.Entry)
+		}
+	}
+
+	var cpu *munch.Cpu6502
+	switch strings.ToLower(*cpuType) {
+	case "6502":
+		cpu = munch.NewCpu6502(bus)
+	case "65c02":
+		cpu = munch.NewCpu65C02(bus)
+	case "6510":
+		cpu = munch.NewCpu6510(bus)
+	case "2a03":
+		cpu = munch.NewCpu2A03(bus)
+	default:
+		fatalf("unknown cpu %q", *cpuType)
+	}
+	cpu.CycleAccurate = *accurate
+
+	symbols := munch.NewSymbols()
+	for _, f := range []struct {
+		name string
+		read func(io.Reader) error
+	}{
+		{*mapFile, symbols.ReadLd65Map},
+		{*labelFile, symbols.ReadViceLabels},
+		{*dbgFile, symbols.ReadCa65DbgInfo},
+	} {
+		if f.name == "" {
+			continue
+		}
+		if err := readFile(f.name, f.read); err != nil {
+			fatalf("%s: %v", f.name, err)
+		}
+		cpu.Symbols = symbols
+	}
+
+	mon := munch.NewMonitor(cpu, os.Stdout)
+	if *pc == "" {
+		*pc = entry
+	}
+	if *pc != "" {
+		if err := mon.Exec("r pc=" + *pc); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			mon.Interrupt()
+		}
+	}()
+
+	if err := mon.Run(os.Stdin); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func readFile(name string, read func(io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "munchmon: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,337 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// NewCpu2A03 creates the Ricoh 2A03 used in the NES, an NMOS 6502 without decimal mode, and maps
+// its APU and DMA registers at $4000-$401f above anything else mapped there.
+func NewCpu2A03(bus *Bus) *Cpu6502 {
+	cpu := newCpu(bus, RP2A03)
+	cpu.DisableDecimal = true
+	cpu.APU = newApu2A03(cpu, bus)
+	bus.Addressable(0x4000, 0x401f, cpu.APU).SetPriority(1)
+	bus.Ticker(cpu.APU)
+	return cpu
+}
+
+// Apu2A03 is the register block of the 2A03 at $4000-$401f. It runs OAM DMA, copying a page of
+// memory to the PPU's OAMDATA at $2004 when $4014 is written, and the DMC's sample fetches,
+// stopping the CPU with RDY while they use the bus. Sprite DMA takes 513 cycles, or 514 when
+// $4014 is written on an odd tick, and each sample fetch 3 or 4. Outside of cycle accurate mode
+// instructions write on their first cycle, so the parity may differ. The DMC and frame counter
+// raise IRQ. The sound channels themselves are left to Sound.
+type Apu2A03 struct {
+	// Input is read at $4016 and $4017, as offsets 0 and 1, and written at $4016, for the
+	// controllers.
+	Input Addressable
+	// Sound is passed writes to the sound registers $4000-$4017 as offsets from $4000, and
+	// supplies the length counter status in bits 0-3 of $4015.
+	Sound Addressable
+
+	dmc   dmcChannel
+	frame frameCounter
+	dma   dmaUnit
+
+	cpu *Cpu6502
+	bus *Bus
+}
+
+// dmcRates are the NTSC periods of the DMC's timer in CPU cycles.
+var dmcRates = [16]uint16{428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54}
+
+// dmcChannel is the part of the delta modulation channel that decides when samples are fetched.
+type dmcChannel struct {
+	IrqEnabled bool
+	Loop       bool
+	Period     uint16
+	Timer      uint16
+	Output     uint8
+
+	SampleAddr   uint16
+	SampleLength uint16
+	Addr         uint16
+	Remaining    uint16 // bytes of the sample left to fetch
+	Buffer       uint8
+	BufferFull   bool
+	Shift        uint8
+	Bits         int
+	Silence      bool
+	Irq          bool
+}
+
+// frameCounter only models the frame interrupt, raised every frame in 4 step mode.
+type frameCounter struct {
+	FiveStep bool
+	Inhibit  bool
+	Cycle    int
+	Irq      bool
+}
+
+const frameCycles = 29830
+
+type dmaUnit struct {
+	Oam      bool  // copying a page to OAM
+	OamPage  uint8 // page being copied
+	OamIndex int
+	OamData  uint8
+	OamRead  bool // OamData is waiting to be written
+	Dmc      bool // fetching a sample byte
+	DmcDummy bool // still to take the dummy cycle before the fetch
+	Halted   bool // the CPU has stopped
+}
+
+func newApu2A03(cpu *Cpu6502, bus *Bus) *Apu2A03 {
+	a := &Apu2A03{cpu: cpu, bus: bus}
+	a.reset()
+	return a
+}
+
+func (a *Apu2A03) reset() {
+	a.dmc = dmcChannel{Period: dmcRates[0], SampleAddr: 0xc000, SampleLength: 1, Bits: 8, Silence: true}
+	a.frame = frameCounter{}
+	a.dma = dmaUnit{}
+	a.updateLines()
+}
+
+func (a *Apu2A03) Read(addr uint16) uint8 {
+	switch addr {
+	case 0x15:
+		v := a.status()
+		a.frame.Irq = false
+		a.updateLines()
+		return v
+	case 0x16, 0x17:
+		if a.Input != nil {
+			return a.Input.Read(addr - 0x16)
+		}
+	}
+	return 0
+}
+
+func (a *Apu2A03) Peek(addr uint16) uint8 {
+	switch addr {
+	case 0x15:
+		return a.status()
+	case 0x16, 0x17:
+		if p, ok := a.Input.(Peeker); ok {
+			return p.Peek(addr - 0x16)
+		}
+	}
+	return 0
+}
+
+func (a *Apu2A03) status() uint8 {
+	var v uint8
+	if s, ok := a.Sound.(Peeker); ok {
+		v = s.Peek(0x15) & 0x0f
+	} else if a.Sound != nil {
+		v = a.Sound.Read(0x15) & 0x0f
+	}
+	if a.dmc.Remaining > 0 {
+		v |= 0x10
+	}
+	if a.frame.Irq {
+		v |= 0x40
+	}
+	if a.dmc.Irq {
+		v |= 0x80
+	}
+	return v
+}
+
+func (a *Apu2A03) Write(addr uint16, v uint8) {
+	switch addr {
+	case 0x10:
+		a.dmc.IrqEnabled = v&0x80 != 0
+		a.dmc.Loop = v&0x40 != 0
+		a.dmc.Period = dmcRates[v&0x0f]
+		if !a.dmc.IrqEnabled {
+			a.dmc.Irq = false
+		}
+	case 0x11:
+		a.dmc.Output = v & 0x7f
+	case 0x12:
+		a.dmc.SampleAddr = 0xc000 | uint16(v)<<6
+	case 0x13:
+		a.dmc.SampleLength = uint16(v)<<4 | 1
+	case 0x14:
+		a.dma.Oam = true
+		a.dma.OamPage = v
+		a.dma.OamIndex = 0
+		a.dma.OamRead = false
+	case 0x15:
+		a.dmc.Irq = false
+		if v&0x10 == 0 {
+			a.dmc.Remaining = 0
+		} else if a.dmc.Remaining == 0 {
+			a.dmc.Addr = a.dmc.SampleAddr
+			a.dmc.Remaining = a.dmc.SampleLength
+		}
+		a.requestSample()
+	case 0x16:
+		if a.Input != nil {
+			a.Input.Write(0, v)
+		}
+		return
+	case 0x17:
+		a.frame.FiveStep = v&0x80 != 0
+		a.frame.Inhibit = v&0x40 != 0
+		a.frame.Cycle = 0
+		if a.frame.Inhibit {
+			a.frame.Irq = false
+		}
+	}
+	if addr <= 0x17 && addr != 0x14 && a.Sound != nil {
+		a.Sound.Write(addr, v)
+	}
+	a.updateLines()
+}
+
+// requestSample starts a DMA fetch if the DMC's buffer is empty and there is more sample to play.
+func (a *Apu2A03) requestSample() {
+	if !a.dmc.BufferFull && a.dmc.Remaining > 0 && !a.dma.Dmc {
+		a.dma.Dmc = true
+		a.dma.DmcDummy = true
+	}
+}
+
+func (a *Apu2A03) Tick() error {
+	if !a.frame.FiveStep {
+		a.frame.Cycle++
+		if a.frame.Cycle == frameCycles {
+			a.frame.Cycle = 0
+			if !a.frame.Inhibit {
+				a.frame.Irq = true
+			}
+		}
+	}
+
+	if a.dmc.Timer == 0 {
+		a.dmc.Timer = a.dmc.Period - 1
+		a.clockDmcOutput()
+	} else {
+		a.dmc.Timer--
+	}
+
+	if a.dma.Oam || a.dma.Dmc {
+		a.tickDma()
+	}
+	a.updateLines()
+	return nil
+}
+
+// clockDmcOutput plays a bit of the sample, taking the next byte from the buffer once all 8 have
+// been played.
+func (a *Apu2A03) clockDmcOutput() {
+	d := &a.dmc
+	if !d.Silence {
+		if d.Shift&1 != 0 {
+			if d.Output <= 125 {
+				d.Output += 2
+			}
+		} else if d.Output >= 2 {
+			d.Output -= 2
+		}
+	}
+	d.Shift >>= 1
+	d.Bits--
+	if d.Bits == 0 {
+		d.Bits = 8
+		d.Silence = !d.BufferFull
+		if d.BufferFull {
+			d.Shift = d.Buffer
+			d.BufferFull = false
+		}
+		a.requestSample()
+	}
+}
+
+// fillSample puts a fetched byte in the DMC's buffer.
+func (a *Apu2A03) fillSample(v uint8) {
+	d := &a.dmc
+	d.Buffer = v
+	d.BufferFull = true
+	d.Addr++
+	if d.Addr == 0 {
+		d.Addr = 0x8000
+	}
+	d.Remaining--
+	if d.Remaining == 0 {
+		if d.Loop {
+			d.Addr = d.SampleAddr
+			d.Remaining = d.SampleLength
+		} else if d.IrqEnabled {
+			d.Irq = true
+		}
+	}
+}
+
+// tickDma runs a cycle of DMA. The CPU only stops on a read cycle, so the DMA waits until it has.
+// Reads are made on even ticks and writes on odd ones, with alignment cycles in between as needed,
+// and a sample fetch takes a dummy cycle first and has priority over OAM.
+func (a *Apu2A03) tickDma() {
+	d := &a.dma
+	if !d.Halted {
+		d.Halted = a.cpu.rdyStalled || a.cpu.halted
+		return
+	}
+
+	get := a.bus.TickCount()%2 == 0
+	switch {
+	case !get && d.OamRead:
+		a.bus.Write(0x2004, d.OamData)
+		d.OamRead = false
+		d.OamIndex++
+		d.Oam = d.OamIndex < 0x100
+		d.DmcDummy = false
+	case d.Dmc && d.DmcDummy:
+		d.DmcDummy = false
+	case get && d.Dmc:
+		d.Dmc = false
+		a.fillSample(a.bus.Read(a.dmc.Addr))
+	case get && d.Oam:
+		d.OamData = a.bus.Read(uint16(d.OamPage)<<8 | uint16(d.OamIndex))
+		d.OamRead = true
+	}
+
+	if !d.Oam && !d.Dmc {
+		d.Halted = false
+	}
+}
+
+func (a *Apu2A03) updateLines() {
+	a.cpu.RDY.Set(a, a.dma.Oam || a.dma.Dmc)
+	a.cpu.IRQ.Set(a, a.frame.Irq || a.dmc.Irq)
+}
+
+type apuSnapshot struct {
+	Dmc   dmcChannel
+	Frame frameCounter
+	Dma   dmaUnit
+}
+
+func (a *Apu2A03) Snapshot() ([]byte, error) {
+	return encodeSnapshot(apuSnapshot{Dmc: a.dmc, Frame: a.frame, Dma: a.dma})
+}
+
+func (a *Apu2A03) Restore(data []byte) error {
+	var s apuSnapshot
+	if err := decodeSnapshot(data, &s); err != nil {
+		return err
+	}
+	a.dmc, a.frame, a.dma = s.Dmc, s.Frame, s.Dma
+	a.updateLines()
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// oamRecorder stands in for the PPU, recording what is written to OAMDATA
+type oamRecorder struct {
+	oam []uint8
+}
+
+func (o *oamRecorder) Read(addr uint16) uint8 { return 0 }
+
+func (o *oamRecorder) Write(addr uint16, v uint8) {
+	if addr == 4 {
+		o.oam = append(o.oam, v)
+	}
+}
+
+func newTest2A03(accurate bool, program ...uint8) (*Bus, *Cpu6502, *Ram, *oamRecorder) {
+	bus, cpu, ram := newRamCpu(NewCpu2A03, program...)
+	ppu := &oamRecorder{}
+	bus.Addressable(0x2000, 0x2007, ppu).SetPriority(1)
+	for i := 0; i < 0x100; i++ {
+		ram.Write(uint16(0x0200+i), uint8(i))
+	}
+	cpu.CycleAccurate = accurate
+	return bus, cpu, ram, ppu
+}
+
+func TestOamDma(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		program []uint8
+		cycles  int
+	}{
+		// LDA #$02, STA $4014, NOP written on tick 6
+		{"even", []uint8{0xa9, 0x02, 0x8d, 0x14, 0x40, 0xea}, 2 + 4 + 2 + 513},
+		// LDA $00, LDA #$02, STA $4014, NOP written on tick 9
+		{"odd", []uint8{0xa5, 0x00, 0xa9, 0x02, 0x8d, 0x14, 0x40, 0xea}, 3 + 2 + 4 + 2 + 514},
+	} {
+		for _, accurate := range []bool{true, false} {
+			bus, cpu, _, ppu := newTest2A03(accurate, tc.program...)
+			for cpu.PC != uint16(0x0400+len(tc.program)) {
+				step(bus, cpu)
+			}
+			// outside cycle accurate mode STA writes on its first cycle, changing the parity
+			if n := int(bus.TickCount()); accurate && n != tc.cycles {
+				t.Errorf("%s accurate=%v: program took %d cycles, expected %d", tc.name, accurate, n, tc.cycles)
+			}
+			if len(ppu.oam) != 0x100 {
+				t.Fatalf("%s accurate=%v: %d bytes written to OAM", tc.name, accurate, len(ppu.oam))
+			}
+			for i, v := range ppu.oam {
+				if v != uint8(i) {
+					t.Errorf("%s accurate=%v: OAM byte %d is $%02x", tc.name, accurate, i, v)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDmcDma(t *testing.T) {
+	// LDA #$8f, STA $4010, LDA #$10, STA $4015, NOP, NOP
+	bus, cpu, ram, _ := newTest2A03(true, 0xa9, 0x8f, 0x8d, 0x10, 0x40, 0xa9, 0x10, 0x8d, 0x15, 0x40, 0xea, 0xea)
+	ram.Write(0xc000, 0x55)
+	for cpu.PC != 0x040a {
+		step(bus, cpu)
+	}
+	if cpu.APU.Peek(0x15) != 0x10 {
+		t.Errorf("status $%02x while the sample is playing", cpu.APU.Peek(0x15))
+	}
+	start := bus.TickCount()
+	for cpu.PC != 0x040b {
+		step(bus, cpu)
+	}
+	if n := bus.TickCount() - start; n != 2+4 {
+		t.Errorf("NOP during a sample fetch took %d cycles", n)
+	}
+	if !cpu.APU.dmc.BufferFull || cpu.APU.dmc.Buffer != 0x55 {
+		t.Errorf("sample buffer holds $%02x", cpu.APU.dmc.Buffer)
+	}
+	if !cpu.IRQ.Asserted() || cpu.APU.Peek(0x15) != 0x80 {
+		t.Errorf("no IRQ at the end of the sample, status $%02x", cpu.APU.Peek(0x15))
+	}
+	bus.Write(0x4015, 0x00)
+	if cpu.IRQ.Asserted() {
+		t.Errorf("writing $4015 didn't acknowledge the IRQ")
+	}
+}
+
+func TestFrameIrq(t *testing.T) {
+	bus, cpu, _, _ := newTest2A03(true)
+	tickFor(bus, frameCycles)
+	if !cpu.IRQ.Asserted() {
+		t.Fatal("no frame IRQ")
+	}
+	if v := bus.Read(0x4015); v != 0x40 {
+		t.Errorf("status $%02x with the frame IRQ raised", v)
+	}
+	if cpu.IRQ.Asserted() {
+		t.Error("reading $4015 didn't acknowledge the frame IRQ")
+	}
+	bus.Write(0x4017, 0x40)
+	tickFor(bus, frameCycles)
+	if cpu.IRQ.Asserted() {
+		t.Error("frame IRQ raised while inhibited")
+	}
+}
+
+func TestNoDecimal2A03(t *testing.T) {
+	// SED, CLC, LDA #$09, ADC #$01
+	bus, cpu, _, _ := newTest2A03(false, 0xf8, 0x18, 0xa9, 0x09, 0x69, 0x01)
+	for cpu.PC != 0x0406 {
+		step(bus, cpu)
+	}
+	if cpu.A != 0x0a {
+		t.Errorf("ADC gave $%02x in decimal mode", cpu.A)
+	}
+}
+
+// TestNestest runs nestest in its automated mode from $c000 and compares the trace with
+// Nintendulator's log, leaving out the PPU column.
+func TestNestest(t *testing.T) {
+	rom, err := os.ReadFile("functional_tests/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile("functional_tests/nestest.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := NewBus()
+	bus.AddressableMirrored(0x0000, 0x1fff, 0x0800, NewRam(0x0800))
+	bus.AddressableMirrored(0x8000, 0xffff, 0x4000, NewRom(rom[16:16+0x4000]))
+	cpu := NewCpu2A03(bus)
+	cpu.CycleAccurate = true
+	cpu.PC = 0xc000
+	cpu.A = 0
+	cpu.P = 0x24
+
+	// nestest.log starts after the 7 cycles of reset
+	var trace bytes.Buffer
+	nestest := NewNestestTracer(&trace)
+	cpu.Tracer = TracerFunc(func(r *TraceRecord) {
+		r.Cycle += 7
+		nestest.Trace(r)
+	})
+
+	ppu := regexp.MustCompile(` PPU:\s*\d+,\s*\d+`)
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for n := 1; scanner.Scan(); n++ {
+		expected := ppu.ReplaceAllString(strings.TrimRight(scanner.Text(), " \r"), "")
+		trace.Reset()
+		step(bus, cpu)
+		if got := strings.TrimRight(trace.String(), "\n"); got != expected {
+			t.Fatalf("line %d\n got %s\nwant %s", n, got, expected)
+		}
+	}
+}
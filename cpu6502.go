@@ -17,7 +17,6 @@ package munch
 
 import (
 	"fmt"
-	"log"
 	"strings"
 )
 
@@ -29,17 +28,126 @@ type Cpu6502 struct {
 	P  uint8  // Status register
 	PC uint16 // PC register
 
+	// Debug prints a trace of every instruction to stdout, unless Tracer is set.
 	Debug          bool
 	DisableDecimal bool
 
+	// Tracer is passed a record of every instruction executed.
+	Tracer Tracer
+
+	// Symbols names the addresses in disassembly and traces.
+	Symbols *Symbols
+
+	// CycleAccurate spreads each instruction over the cycles it takes, making one bus access
+	// per Tick just like the real processor. Otherwise the whole instruction is executed on its
+	// first cycle and the CPU then idles for the rest.
+	CycleAccurate bool
+
+	InvalidOpcodePolicy  InvalidOpcodePolicy
+	InvalidOpcodeHandler func(*InvalidOpcodeError) error
+
+	Unstable UnstableModel
+
+	// IRQ is level triggered and serviced at the end of any instruction while it is asserted and
+	// the I flag is clear. NMI is edge triggered and serviced once each time it is asserted.
+	IRQ *InterruptLine
+	NMI *InterruptLine
+
+	// Asserting RDY pulls it low, stopping the CPU on its next read cycle, or any cycle on the
+	// 65C02, until it is released. Asserting SO pulls it low, setting the V flag.
+	RDY *InterruptLine
+	SO  *InterruptLine
+
+	// Port is the 6510's I/O port, nil for the other variants.
+	Port *IOPort
+	// APU is the 2A03's APU and DMA registers, nil for the other variants.
+	APU *Apu2A03
+
 	waitCycles int
+	// instructions counts the instructions and interrupts started
+	instructions uint64
 
 	opCodes [0x100]*opcode
 
+	// State of the instruction in progress
+	op     *opcode
+	opAddr uint16  // address of the opcode
+	cycles []cycle // cycles of the instruction
+	step   int     // next cycle to run
+	addr   uint16  // effective address
+	base   uint16  // address before indexing
+	ptr    uint8   // zero page pointer
+	data   uint8   // operand or value being modified
+	vector uint16  // interrupt vector being followed
+	inBrk  bool    // executing BRK rather than servicing an interrupt
+	err    error
+
+	// Trace of the instruction in progress
+	tracing    bool
+	trace      TraceRecord
+	traceBytes [3]uint8
+
 	bus *Bus
 
-	pendingIrq bool
-	pendingNmi bool
+	pendingIrq bool // requested by Irq
+	pendingNmi bool // latched by an edge on NMI, or Nmi
+	nmiLevel   bool // NMI when last sampled
+	soLevel    bool // SO when last sampled
+	rdyStalled bool // RDY stopped the CPU on the last Tick
+
+	// Interrupts are polled on the penultimate cycle of each instruction
+	polled        bool // whether the instruction in progress has polled
+	irqEnabled    bool // the I flag was clear when it polled
+	interruptNext bool // an interrupt is to be serviced after it
+	skipPoll      bool // the next poll is skipped
+
+	halted  bool
+	waiting bool
+
+	variant Variant
+}
+
+// Variant selects which member of the 6502 family is emulated.
+type Variant int
+
+const (
+	// NMOS6502 is the original MOS Technology 6502 including its unofficial opcodes.
+	NMOS6502 Variant = iota
+	// WDC65C02 is the Western Design Center W65C02S CMOS part.
+	WDC65C02
+	// MOS6510 is the NMOS 6502 with the I/O port at $0000 and $0001 used in the Commodore 64.
+	MOS6510
+	// RP2A03 is the Ricoh 2A03 in the NES, an NMOS 6502 without decimal mode.
+	RP2A03
+)
+
+// UnstableModel holds the chip dependent "magic" constants ORed into the accumulator by the
+// unstable ANE ($8b) and LXA ($ab) opcodes. The value depends on the production run of the chip
+// and can even vary with temperature, so choose the model matching the machine being emulated.
+type UnstableModel struct {
+	AneMagic uint8
+	LxaMagic uint8
+}
+
+var (
+	// UnstableModelDefault matches the values seen on most NMOS 6502 parts and used by the
+	// SingleStepTests processor test suite.
+	UnstableModelDefault = UnstableModel{AneMagic: 0xee, LxaMagic: 0xee}
+	// UnstableModel6510 matches the values commonly measured on Commodore 64 6510 parts.
+	UnstableModel6510 = UnstableModel{AneMagic: 0xef, LxaMagic: 0xee}
+	// UnstableModelStable removes the magic constant so ANE ANDs A with both X and the operand
+	// and LXA behaves like LAX #imm.
+	UnstableModelStable = UnstableModel{AneMagic: 0xff, LxaMagic: 0xff}
+)
+
+// Registers is a copy of the programmer visible CPU state.
+type Registers struct {
+	A  uint8
+	X  uint8
+	Y  uint8
+	SP uint8
+	P  uint8
+	PC uint16
 }
 
 type Flag uint8
@@ -56,10 +164,31 @@ const (
 )
 
 func NewCpu6502(bus *Bus) *Cpu6502 {
+	return newCpu(bus, NMOS6502)
+}
+
+func NewCpu65C02(bus *Bus) *Cpu6502 {
+	return newCpu(bus, WDC65C02)
+}
+
+func newCpu(bus *Bus, variant Variant) *Cpu6502 {
 	cpu := &Cpu6502{
-		bus: bus,
+		bus:      bus,
+		Unstable: UnstableModelDefault,
+		IRQ:      NewInterruptLine(),
+		NMI:      NewInterruptLine(),
+		RDY:      NewInterruptLine(),
+		SO:       NewInterruptLine(),
+		variant:  variant,
 	}
 	cpu.initOpcodes()
+	if variant == WDC65C02 {
+		cpu.initOpcodes65C02()
+	}
+	if variant == MOS6510 {
+		cpu.Port = newIOPort(bus)
+	}
+	cpu.initCycles()
 
 	bus.Ticker(cpu)
 
@@ -77,71 +206,211 @@ func (cpu *Cpu6502) Reset() {
 	cpu.PC = readWord(cpu.bus, 0xfffc)
 
 	cpu.waitCycles = 0
+	cpu.cycles = nil
+	cpu.step = 0
+	cpu.halted = false
+	cpu.waiting = false
+	cpu.pendingIrq = false
+	cpu.pendingNmi = false
+	cpu.nmiLevel = cpu.NMI.Asserted()
+	cpu.soLevel = cpu.SO.Asserted()
+	cpu.polled = false
+	cpu.interruptNext = false
+	cpu.skipPoll = false
+	if cpu.Port != nil {
+		cpu.Port.reset()
+	}
+	if cpu.APU != nil {
+		cpu.APU.reset()
+	}
 }
 
+func (cpu *Cpu6502) Variant() Variant {
+	return cpu.variant
+}
+
+// Halted reports whether the CPU has executed one of the JAM opcodes, or STP on the 65C02. A
+// halted CPU ignores interrupts and does nothing until Reset is called.
+func (cpu *Cpu6502) Halted() bool {
+	return cpu.halted
+}
+
+// Irq requests a single IRQ, which is held until the I flag is clear and it is serviced. Devices
+// should assert IRQ instead.
 func (cpu *Cpu6502) Irq() {
-	if !cpu.FlagSet(P_DISABLE_IRQ) {
-		cpu.pendingIrq = true
-	}
+	cpu.pendingIrq = true
 }
 
+// Nmi requests a single NMI, as though NMI had been asserted and released.
 func (cpu *Cpu6502) Nmi() {
 	cpu.pendingNmi = true
 }
 
+// irqActive reports whether an IRQ would be serviced, ignoring the I flag.
+func (cpu *Cpu6502) irqActive() bool {
+	return cpu.pendingIrq || cpu.IRQ.Asserted()
+}
+
 func (cpu *Cpu6502) Tick() error {
+	if cpu.halted {
+		return nil
+	}
+
+	if level := cpu.NMI.Asserted(); level != cpu.nmiLevel {
+		cpu.nmiLevel = level
+		if level {
+			cpu.pendingNmi = true
+		}
+	}
+	if level := cpu.SO.Asserted(); level != cpu.soLevel {
+		cpu.soLevel = level
+		if level {
+			cpu.SetFlag(P_OVERFLOW)
+		}
+	}
+
+	cpu.rdyStalled = cpu.RDY.Asserted() && cpu.stalled()
+	if cpu.rdyStalled {
+		return nil
+	}
+
 	if cpu.waitCycles > 0 {
 		cpu.waitCycles--
 		return nil
 	}
 
-	if cpu.pendingIrq || cpu.pendingNmi {
-		cpu.stackPushWord(cpu.PC + 1)
-		cpu.stackPush(cpu.P &^ uint8(P_BRK_COMMAND))
-		cpu.SetFlag(P_DISABLE_IRQ)
+	if cpu.step >= len(cpu.cycles) && !cpu.startInstruction() {
+		return nil
+	}
+
+	if cpu.CycleAccurate {
+		return cpu.runCycle()
+	}
+
+	n := 0
+	for cpu.step < len(cpu.cycles) {
+		n++
+		if err := cpu.runCycle(); err != nil {
+			return err
+		}
+	}
+	cpu.waitCycles = n - 1
+	return nil
+}
+
+// stalled reports whether RDY stops the CPU on this cycle. Outside of cycle accurate mode the
+// instruction has already run so the CPU just stops counting down its cycles.
+func (cpu *Cpu6502) stalled() bool {
+	if !cpu.CycleAccurate || cpu.variant == WDC65C02 {
+		return true
+	}
+	return !cpu.nextCycleWrites()
+}
+
+// poll decides whether to service an interrupt once the instruction in progress finishes. It is
+// called at the end of the penultimate cycle, so when CLI, SEI and PLP change the I flag on their
+// last cycle the change doesn't take effect until after the next instruction.
+func (cpu *Cpu6502) poll() {
+	cpu.polled = true
+	cpu.irqEnabled = !cpu.FlagSet(P_DISABLE_IRQ)
+	cpu.interruptNext = cpu.pendingNmi || (cpu.irqEnabled && cpu.irqActive())
+}
+
+// startInstruction sets up the cycles of the next instruction, or of servicing an interrupt. It
+// returns false if the CPU is waiting for an interrupt and has nothing to do.
+func (cpu *Cpu6502) startInstruction() bool {
+	interrupt := cpu.interruptNext
+	if cpu.polled && !cpu.CycleAccurate {
+		// The whole instruction ran on its first cycle, long before the real poll, so look at
+		// the interrupt lines as they are now
+		interrupt = cpu.pendingNmi || (cpu.irqEnabled && cpu.irqActive())
+	}
+	if cpu.waiting {
+		// WAI carries on when an IRQ is asserted even if the I flag stops it being serviced
+		if !cpu.irqActive() && !cpu.pendingNmi {
+			return false
+		}
+		cpu.waiting = false
+		interrupt = cpu.pendingNmi || (cpu.irqActive() && !cpu.FlagSet(P_DISABLE_IRQ))
+	}
+	cpu.polled = false
+	cpu.interruptNext = false
+
+	cpu.step = 0
+	cpu.instructions++
+	if interrupt {
+		cpu.op = nil
+		cpu.cycles = interruptCycles
+		cpu.inBrk = false
 		if cpu.pendingNmi {
-			cpu.PC = readWord(cpu.bus, 0xfffa)
+			cpu.vector = 0xfffa
+			cpu.pendingNmi = false
 		} else {
-			cpu.PC = readWord(cpu.bus, 0xfffe)
+			cpu.vector = 0xfffe
 		}
-		cpu.waitCycles = 7
-		cpu.pendingIrq = false
-		cpu.pendingNmi = false
-		return nil
+		return true
 	}
 
-	var debugStr string
-	if cpu.Debug {
-		asm, _ := cpu.Disassemble(cpu.PC)
-		debugStr = fmt.Sprintf("%04x    %-26s", cpu.PC, asm)
+	if cpu.Debug || cpu.Tracer != nil {
+		cpu.startTrace()
 	}
+	cpu.cycles = fetchCycles
+	return true
+}
 
-	opcode := cpu.bus.Read(cpu.PC)
-	cpu.PC++
+func (cpu *Cpu6502) runCycle() error {
+	cpu.cycles[cpu.step](cpu)
+	cpu.step++
 
-	op := cpu.opCodes[opcode]
-	if op == nil {
-		log.Fatalf("Invalid opcode 0x%02x at 0x%04x", opcode, cpu.PC-1)
-		return nil
+	if cpu.step == len(cpu.cycles)-1 {
+		if cpu.skipPoll {
+			cpu.skipPoll = false
+		} else {
+			cpu.poll()
+		}
 	}
-	argAddr := cpu.PC
-	var arg uint16
-	if op.addrMode.args == 1 {
-		arg = uint16(cpu.bus.Read(argAddr))
-	} else if op.addrMode.args == 2 {
-		arg = readWord(cpu.bus, argAddr)
+
+	if cpu.tracing {
+		cpu.trace.Cycles++
+		if cpu.step >= len(cpu.cycles) {
+			cpu.finishTrace()
+		}
 	}
-	cpu.PC += uint16(op.addrMode.args)
-	addr := op.addrMode.addr(cpu, argAddr, arg)
-	op.exec(addr)
-	cpu.waitCycles += op.wait
 
-	if cpu.Debug {
-		fmt.Printf("%s%s\n", debugStr, cpu.StatusString())
+	err := cpu.err
+	cpu.err = nil
+	return err
+}
+
+func (cpu *Cpu6502) invalidOpcode(opcode uint8, addr uint16) error {
+	regs := cpu.Registers()
+	regs.PC = addr
+	err := &InvalidOpcodeError{Opcode: opcode, Addr: addr, Registers: regs}
+
+	switch cpu.InvalidOpcodePolicy {
+	case InvalidOpcodeNop:
+	case InvalidOpcodeTrap:
+		if cpu.InvalidOpcodeHandler == nil {
+			cpu.PC = addr
+			return err
+		}
+		if herr := cpu.InvalidOpcodeHandler(err); herr != nil {
+			cpu.PC = addr
+			return herr
+		}
+	default:
+		cpu.PC = addr
+		return err
 	}
+
+	cpu.continueWith(invalidNopCycles)
 	return nil
 }
 
+func (cpu *Cpu6502) Registers() Registers {
+	return Registers{A: cpu.A, X: cpu.X, Y: cpu.Y, SP: cpu.SP, P: cpu.P, PC: cpu.PC}
+}
+
 func (cpu *Cpu6502) SetFlag(flag Flag)      { cpu.P = cpu.P | uint8(flag) }
 func (cpu *Cpu6502) ClearFlag(flag Flag)    { cpu.P = cpu.P &^ uint8(flag) }
 func (cpu *Cpu6502) FlagSet(flag Flag) bool { return cpu.P&uint8(flag) != 0x00 }
@@ -153,34 +422,42 @@ func (cpu *Cpu6502) SetFlagValue(flag Flag, v bool) {
 	}
 }
 
+// Waiting reports whether the CPU is part way through an instruction.
 func (cpu *Cpu6502) Waiting() bool {
-	return cpu.waitCycles > 0
+	return cpu.waitCycles > 0 || cpu.step < len(cpu.cycles)
 }
 
 func (cpu *Cpu6502) Disassemble(addr uint16) (string, uint16) {
-	opcode := cpu.bus.Read(addr)
+	opcode := cpu.peek(addr)
 	op := cpu.opCodes[opcode]
 
 	if op == nil {
-		log.Fatalf("Invalid opcode 0x%02x at 0x%04x", opcode, cpu.PC-1)
-		return "", 0
+		return fmt.Sprintf(".byte $%02x", opcode), 1
 	}
 
 	var arg uint16
 	if op.addrMode.args == 1 {
-		arg = uint16(cpu.bus.Read(addr + 1))
+		arg = uint16(cpu.peek(addr + 1))
 	} else if op.addrMode.args == 2 {
-		arg = readWord(cpu.bus, addr+1)
+		arg = cpu.peekWord(addr + 1)
 	}
 
-	return strings.TrimSpace(op.mne + " " + op.addrMode.fmt(addr+1, arg)), uint16(op.addrMode.args + 1)
+	operand := op.addrMode.fmt(addr+1, arg)
+	if cpu.Symbols != nil {
+		operand = cpu.Symbols.symbolize(operand, op.addrMode.name, addr+1, arg)
+	}
+	return strings.TrimSpace(op.mne + " " + operand), uint16(op.addrMode.args + 1)
 }
 
 func (cpu *Cpu6502) StatusString() string {
+	return cpu.Registers().String()
+}
+
+func (r Registers) String() string {
 	low := "nv-bdizc"
 	var statusReg string
 	for i := 7; i >= 0; i-- {
-		if (1<<i)&cpu.P != 0 {
+		if (1<<i)&r.P != 0 {
 			statusReg += strings.ToUpper(string(low[7-i]))
 		} else {
 			statusReg += strings.ToLower(string(low[7-i]))
@@ -188,37 +465,54 @@ func (cpu *Cpu6502) StatusString() string {
 	}
 	return fmt.Sprintf(
 		"PC:%04x A:%02x X:%02x Y:%02x SP:%02x %s",
-		cpu.PC,
-		cpu.A,
-		cpu.X,
-		cpu.Y,
-		cpu.SP, statusReg,
+		r.PC,
+		r.A,
+		r.X,
+		r.Y,
+		r.SP, statusReg,
 	)
 
 }
 
-func (cpu *Cpu6502) stackPush(a uint8) {
-	cpu.bus.Write(uint16(0x100)+uint16(cpu.SP), a)
-	cpu.SP -= 1
+// read and write make the CPU's bus accesses. The 6510's I/O port answers for $0000 and $0001,
+// though the access still appears on the bus.
+func (cpu *Cpu6502) read(addr uint16) uint8 {
+	v := cpu.bus.Read(addr)
+	if addr < 2 && cpu.Port != nil {
+		return cpu.Port.read(addr)
+	}
+	return v
 }
 
-func (cpu *Cpu6502) stackPushWord(a uint16) {
-	cpu.stackPush(uint8(a >> 8))
-	cpu.stackPush(uint8(a & 0xff))
+func (cpu *Cpu6502) write(addr uint16, v uint8) {
+	cpu.bus.Write(addr, v)
+	if addr < 2 && cpu.Port != nil {
+		cpu.Port.write(addr, v)
+	}
 }
 
-func (cpu *Cpu6502) stackPop() uint8 {
-	cpu.SP += 1
-	return cpu.bus.Read(uint16(0x100) + uint16(cpu.SP))
+// peek reads memory as the CPU sees it without side effects.
+func (cpu *Cpu6502) peek(addr uint16) uint8 {
+	if addr < 2 && cpu.Port != nil {
+		return cpu.Port.peek(addr)
+	}
+	return cpu.bus.Peek(addr)
 }
 
-func (cpu *Cpu6502) stackPopWord() uint16 {
-	low := cpu.stackPop()
-	high := cpu.stackPop()
+func (cpu *Cpu6502) stackPush(a uint8) {
+	cpu.write(uint16(0x100)+uint16(cpu.SP), a)
+	cpu.SP -= 1
+}
 
-	return uint16(high)<<8 + uint16(low)
+func (cpu *Cpu6502) stackPop() uint8 {
+	cpu.SP += 1
+	return cpu.read(uint16(0x100) + uint16(cpu.SP))
 }
 
 func readWord(dev Addressable, a uint16) uint16 {
 	return uint16(uint16(dev.Read(a))) + (uint16(dev.Read(a+1)) << 8)
 }
+
+func (cpu *Cpu6502) peekWord(a uint16) uint16 {
+	return uint16(cpu.peek(a)) + (uint16(cpu.peek(a+1)) << 8)
+}
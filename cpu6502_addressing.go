@@ -1,3 +1,8 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
 // General Public License as published by the Free Software Foundation, either version 3 of the
 // License, or (at your option) any later version.
 //
@@ -12,89 +17,269 @@ package munch
 
 import "fmt"
 
+// addrMode describes how an instruction's operand is formatted and the cycles that make up the
+// instruction for each kind of memory access. A nil slice means the mode is not used for that
+// kind of access.
 type addrMode struct {
+	name string
 	args int
-	fmt  func(uint16, uint16) string           // func(argAddr, arg) => string
-	addr func(*Cpu6502, uint16, uint16) uint16 // func(argAddr, arg) => address
+	fmt  func(uint16, uint16) string // func(argAddr, arg) => string
+
+	read   []cycle
+	store  []cycle
+	modify []cycle
 }
 
 var (
 	none = addrMode{
+		name: "imp",
 		args: 0,
 		fmt:  func(argAddr, arg uint16) string { return "" },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 { return 0 },
+		read: []cycle{(*Cpu6502).cycImplied},
 	}
 	imm = addrMode{
+		name: "imm",
 		args: 1,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("#$%02x", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 { return argAddr },
+		read: []cycle{(*Cpu6502).cycImmediate},
 	}
 	zp = addrMode{
+		name: "zp",
 		args: 1,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("$%02x", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 { return arg },
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
+		},
 	}
 	zpx = addrMode{
+		name: "zpx",
 		args: 1,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("$%02x,X", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 { return uint16(uint8(arg) + cpu.X) },
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycZeroPageIndexX,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycZeroPageIndexX,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycZeroPageIndexX,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
+		},
 	}
 	zpy = addrMode{
+		name: "zpy",
 		args: 1,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("$%02x,Y", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 { return uint16(uint8(arg) + cpu.Y) },
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycZeroPageIndexY,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycZeroPageIndexY,
+			(*Cpu6502).cycStore,
+		},
 	}
 	izx = addrMode{
+		name: "izx",
 		args: 1,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("($%02x, X)", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 {
-			addr := uint8(arg) + cpu.X
-			return uint16(cpu.bus.Read(uint16(addr))) + uint16(cpu.bus.Read(uint16(addr+1)))<<8
+		read: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycPointerIndexX,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHigh,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycPointerIndexX,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHigh,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycPointerIndexX,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHigh,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
 		},
 	}
 	izy = addrMode{
+		name: "izy",
 		args: 1,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("($%02x),Y", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 {
-			a := uint16(cpu.bus.Read(arg)) + uint16(cpu.bus.Read(uint16(uint8(arg)+1)))<<8
-			return a + uint16(cpu.Y)
+		read: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHighY,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHighY,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHighY,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
 		},
 	}
 	abs = addrMode{
+		name: "abs",
 		args: 2,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("$%04x", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 { return arg },
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHigh,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHigh,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHigh,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
+		},
 	}
 	abx = addrMode{
+		name: "abx",
 		args: 2,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("$%04x,X", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 {
-			addr := arg + uint16(cpu.X)
-			if arg&0xff00 != addr&0xff00 {
-				cpu.waitCycles += 1
-			}
-			return addr
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHighX,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHighX,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHighX,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
 		},
 	}
 	aby = addrMode{
+		name: "aby",
 		args: 2,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("$%04x,Y", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 {
-			addr := arg + uint16(cpu.Y)
-			if arg&0xff00 != addr&0xff00 {
-				cpu.waitCycles += 1
-			}
-			return addr
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHighY,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHighY,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycStore,
+		},
+		modify: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchAddrHighY,
+			(*Cpu6502).cycIndexFixup,
+			(*Cpu6502).cycModifyRead,
+			(*Cpu6502).cycModifyDummy,
+			(*Cpu6502).cycModifyWrite,
 		},
 	}
+	// JMP ($xxxx) is the only user of the indirect modes and has its own cycles
 	ind = addrMode{
+		name: "ind",
+		args: 2,
+		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("($%04x)", arg) },
+	}
+	// 65C02 JMP ($xxxx) without the page wrap bug
+	indFixed = addrMode{
+		name: "ind",
 		args: 2,
 		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("($%04x)", arg) },
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 {
-			return uint16(cpu.bus.Read(arg&0xff00+uint16(uint8(arg)+1)))<<8 + uint16(cpu.bus.Read(arg))
+	}
+	// 65C02 zero page indirect ($xx)
+	izp = addrMode{
+		name: "izp",
+		args: 1,
+		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("($%02x)", arg) },
+		read: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHigh,
+			(*Cpu6502).cycRead,
+		},
+		store: []cycle{
+			(*Cpu6502).cycFetchPointer,
+			(*Cpu6502).cycReadAddrLow,
+			(*Cpu6502).cycReadAddrHigh,
+			(*Cpu6502).cycStore,
+		},
+	}
+	// 65C02 JMP ($xxxx,X)
+	iax = addrMode{
+		name: "iax",
+		args: 2,
+		fmt:  func(argAddr, arg uint16) string { return fmt.Sprintf("($%04x,X)", arg) },
+	}
+	// 65C02 BBR/BBS zero page address followed by a relative branch. The zero page address is
+	// passed to the instruction, the branch offset is left in cpu.data.
+	zpr = addrMode{
+		name: "zpr",
+		args: 2,
+		fmt: func(argAddr, arg uint16) string {
+			return fmt.Sprintf("$%02x,$%04x", arg&0xff, argAddr+2+uint16(int8(arg>>8)))
+		},
+		read: []cycle{
+			(*Cpu6502).cycFetchAddrLow,
+			(*Cpu6502).cycFetchOffset,
+			(*Cpu6502).cycDummyReadAddr,
+			(*Cpu6502).cycRead,
 		},
 	}
 	rel = addrMode{
+		name: "rel",
 		args: 1,
 		fmt: func(argAddr, arg uint16) string {
 			if arg < 0x80 {
@@ -104,13 +289,6 @@ var (
 			}
 			return fmt.Sprintf("$%04x", arg+1)
 		},
-		addr: func(cpu *Cpu6502, argAddr, arg uint16) uint16 {
-			if arg < 0x80 {
-				arg += argAddr
-			} else {
-				arg += argAddr - 0x100
-			}
-			return arg + 1
-		},
+		read: []cycle{(*Cpu6502).cycRelative},
 	}
 )
@@ -0,0 +1,393 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A cycle does the work of a single clock cycle of an instruction. Every cycle makes exactly one
+// bus access, just like the real processor, so the number of cycles an instruction takes falls
+// out of the cycles it is built from.
+type cycle func(*Cpu6502)
+
+var (
+	fetchCycles     = []cycle{(*Cpu6502).cycFetchOpcode}
+	interruptCycles = []cycle{
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycPushPCH,
+		(*Cpu6502).cycPushPCL,
+		(*Cpu6502).cycPushP,
+		(*Cpu6502).cycVectorLow,
+		(*Cpu6502).cycVectorHigh,
+	}
+	brkCycles = []cycle{
+		(*Cpu6502).cycBrk,
+		(*Cpu6502).cycPushPCH,
+		(*Cpu6502).cycPushPCL,
+		(*Cpu6502).cycPushP,
+		(*Cpu6502).cycVectorLow,
+		(*Cpu6502).cycVectorHigh,
+	}
+	jsrCycles = []cycle{
+		(*Cpu6502).cycFetchAddrLow,
+		(*Cpu6502).cycDummyReadStack,
+		(*Cpu6502).cycPushPCH,
+		(*Cpu6502).cycPushPCL,
+		(*Cpu6502).cycJsr,
+	}
+	rtsCycles = []cycle{
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycDummyReadStack,
+		(*Cpu6502).cycPopPCL,
+		(*Cpu6502).cycPopPCH,
+		(*Cpu6502).cycIncrementPC,
+	}
+	rtiCycles = []cycle{
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycDummyReadStack,
+		(*Cpu6502).cycPopP,
+		(*Cpu6502).cycPopPCL,
+		(*Cpu6502).cycPopPCH,
+	}
+	jmpAbsCycles = []cycle{
+		(*Cpu6502).cycFetchAddrLow,
+		(*Cpu6502).cycJmpAbsolute,
+	}
+	jmpIndCycles = []cycle{
+		(*Cpu6502).cycFetchAddrLow,
+		(*Cpu6502).cycFetchAddrHigh,
+		(*Cpu6502).cycReadPointerLow,
+		(*Cpu6502).cycJmpIndirectBug,
+	}
+	jmpIndFixedCycles = []cycle{
+		(*Cpu6502).cycFetchAddrLow,
+		(*Cpu6502).cycFetchAddrHigh,
+		(*Cpu6502).cycDummyReadOperand,
+		(*Cpu6502).cycReadPointerLow,
+		(*Cpu6502).cycJmpIndirect,
+	}
+	jmpIaxCycles = []cycle{
+		(*Cpu6502).cycFetchAddrLow,
+		(*Cpu6502).cycFetchAddrHigh,
+		(*Cpu6502).cycIndexPointerX,
+		(*Cpu6502).cycReadPointerLow,
+		(*Cpu6502).cycJmpIndirect,
+	}
+	pushCycles = []cycle{
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycRead,
+	}
+	pullCycles = []cycle{
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycDummyReadStack,
+		(*Cpu6502).cycRead,
+	}
+	waiStpCycles = []cycle{
+		(*Cpu6502).cycDummyReadPC,
+		(*Cpu6502).cycImplied,
+	}
+	nop5cCycles = []cycle{
+		(*Cpu6502).cycFetchAddrLow,
+		(*Cpu6502).cycFetchAddrHigh,
+		(*Cpu6502).cycDummyReadAddr,
+		(*Cpu6502).cycDummyReadAddr,
+		(*Cpu6502).cycDummyReadAddr,
+		(*Cpu6502).cycDummyReadAddr,
+		(*Cpu6502).cycDummyReadAddr,
+	}
+	noCycles           = []cycle{}
+	invalidNopCycles   = []cycle{(*Cpu6502).cycDummyReadPC}
+	branchTakenCycles  = []cycle{(*Cpu6502).cycBranchTaken}
+	branchFixupCycles  = []cycle{(*Cpu6502).cycBranchFixup}
+	decimalFixupCycles = []cycle{(*Cpu6502).cycDummyReadAddr}
+)
+
+// writeCycles are the cycles that write to the bus rather than read, which RDY can't stop on
+// the NMOS 6502. Cycles can't be compared directly, so they are looked up by their code pointer.
+var writeCycles = map[uintptr]bool{
+	cycleID((*Cpu6502).cycStore):       true,
+	cycleID((*Cpu6502).cycModifyWrite): true,
+	cycleID((*Cpu6502).cycPushPCH):     true,
+	cycleID((*Cpu6502).cycPushPCL):     true,
+	cycleID((*Cpu6502).cycPushP):       true,
+}
+
+func cycleID(c cycle) uintptr {
+	return reflect.ValueOf(c).Pointer()
+}
+
+// nextCycleWrites reports whether the next cycle of the instruction in progress is a write.
+func (c *Cpu6502) nextCycleWrites() bool {
+	if c.step >= len(c.cycles) {
+		return false
+	}
+	id := cycleID(c.cycles[c.step])
+	switch {
+	case writeCycles[id]:
+		return true
+	case id == cycleID((*Cpu6502).cycModifyDummy):
+		// the NMOS 6502 writes the unmodified value back
+		return c.variant != WDC65C02
+	case id == cycleID((*Cpu6502).cycRead):
+		// PHA and friends push in their last cycle
+		return c.op != nil && sameCycles(c.op.cycles, pushCycles)
+	}
+	return false
+}
+
+// initCycles builds the cycles of every opcode that doesn't have its own from the addressing
+// mode and the kind of access the instruction makes.
+func (c *Cpu6502) initCycles() {
+	for i, op := range c.opCodes {
+		if op == nil || op.cycles != nil {
+			continue
+		}
+		switch {
+		case op.modify != nil:
+			op.cycles = op.addrMode.modify
+		case op.store != nil:
+			op.cycles = op.addrMode.store
+		default:
+			// Reads only need to fix up an indexed address if a page was crossed
+			op.fixupOnCross = true
+			op.cycles = op.addrMode.read
+		}
+		if op.cycles == nil {
+			panic(fmt.Sprintf("opcode $%02x: %s has no cycles for its addressing mode", i, op.mne))
+		}
+	}
+}
+
+// continueWith replaces the remaining cycles of the current instruction.
+func (c *Cpu6502) continueWith(cycles []cycle) {
+	c.cycles = cycles
+	c.step = -1
+}
+
+func (c *Cpu6502) fetch() uint8 {
+	v := c.read(c.PC)
+	c.PC++
+	return v
+}
+
+// ================================================================================================
+// Fetching opcodes and operands
+// ================================================================================================
+
+func (c *Cpu6502) cycFetchOpcode() {
+	c.opAddr = c.PC
+	opcode := c.fetch()
+	c.op = c.opCodes[opcode]
+	if c.op == nil {
+		c.err = c.invalidOpcode(opcode, c.opAddr)
+		return
+	}
+	c.continueWith(c.op.cycles)
+}
+
+func (c *Cpu6502) cycFetchAddrLow()  { c.addr = uint16(c.fetch()) }
+func (c *Cpu6502) cycFetchAddrHigh() { c.addr |= uint16(c.fetch()) << 8 }
+func (c *Cpu6502) cycFetchOffset()   { c.data = c.fetch() }
+func (c *Cpu6502) cycFetchPointer()  { c.ptr = c.fetch() }
+
+func (c *Cpu6502) cycFetchAddrHighX() { c.indexAddr(c.addr|uint16(c.fetch())<<8, c.X) }
+func (c *Cpu6502) cycFetchAddrHighY() { c.indexAddr(c.addr|uint16(c.fetch())<<8, c.Y) }
+
+func (c *Cpu6502) cycReadAddrLow()   { c.addr = uint16(c.read(uint16(c.ptr))) }
+func (c *Cpu6502) cycReadAddrHigh()  { c.addr |= uint16(c.read(uint16(c.ptr+1))) << 8 }
+func (c *Cpu6502) cycReadAddrHighY() { c.indexAddr(c.addr|uint16(c.read(uint16(c.ptr+1)))<<8, c.Y) }
+
+// indexAddr adds an index to base, skipping the following fix-up cycle when the instruction only
+// needs it for page crossings and none happened.
+func (c *Cpu6502) indexAddr(base uint16, index uint8) {
+	c.base = base
+	c.addr = base + uint16(index)
+	if c.op.fixupOnCross && c.addr&0xff00 == base&0xff00 {
+		c.step++
+	}
+}
+
+// cycIndexFixup is the cycle in which the processor adds the carry from indexing into the high
+// byte of the address. The NMOS part reads from the not yet fixed address while the 65C02
+// rereads the last operand byte instead.
+func (c *Cpu6502) cycIndexFixup() {
+	if c.variant == WDC65C02 && c.addr&0xff00 != c.base&0xff00 {
+		c.read(c.PC - 1)
+	} else {
+		c.read(c.base&0xff00 | c.addr&0x00ff)
+	}
+}
+
+func (c *Cpu6502) cycZeroPageIndexX() {
+	c.read(c.addr)
+	c.addr = uint16(uint8(c.addr) + c.X)
+}
+
+func (c *Cpu6502) cycZeroPageIndexY() {
+	c.read(c.addr)
+	c.addr = uint16(uint8(c.addr) + c.Y)
+}
+
+func (c *Cpu6502) cycPointerIndexX() {
+	c.read(uint16(c.ptr))
+	c.ptr += c.X
+}
+
+func (c *Cpu6502) cycDummyReadPC()      { c.read(c.PC) }
+func (c *Cpu6502) cycDummyReadOperand() { c.read(c.PC - 1) }
+func (c *Cpu6502) cycDummyReadAddr()    { c.read(c.addr) }
+func (c *Cpu6502) cycDummyReadStack()   { c.read(0x100 | uint16(c.SP)) }
+
+// ================================================================================================
+// Executing instructions
+// ================================================================================================
+
+func (c *Cpu6502) cycRead()  { c.op.exec(c.addr) }
+func (c *Cpu6502) cycStore() { c.op.store(c.addr) }
+
+func (c *Cpu6502) cycImplied() {
+	c.read(c.PC)
+	c.op.exec(0)
+}
+
+func (c *Cpu6502) cycImmediate() {
+	c.addr = c.PC
+	c.PC++
+	c.op.exec(c.addr)
+}
+
+func (c *Cpu6502) cycModifyRead() { c.data = c.read(c.addr) }
+
+// cycModifyDummy is the cycle in which a read-modify-write instruction does the modification.
+// The NMOS part writes the unmodified value back while the 65C02 reads it again.
+func (c *Cpu6502) cycModifyDummy() {
+	if c.variant == WDC65C02 {
+		c.read(c.addr)
+	} else {
+		c.write(c.addr, c.data)
+	}
+}
+
+func (c *Cpu6502) cycModifyWrite() { c.write(c.addr, c.op.modify(c.data)) }
+
+// ================================================================================================
+// Branches and jumps
+// ================================================================================================
+
+func (c *Cpu6502) cycRelative() {
+	c.data = c.fetch()
+	c.op.exec(c.PC + uint16(int8(c.data)))
+}
+
+// branch is called by the branch instructions when the branch is taken. Taking a branch costs a
+// cycle and crossing a page another. Interrupts aren't polled again unless a page is crossed, so
+// one arriving during a taken branch waits for the next instruction.
+func (c *Cpu6502) branch(target uint16) {
+	c.addr = target
+	c.skipPoll = true
+	c.continueWith(branchTakenCycles)
+}
+
+func (c *Cpu6502) cycBranchTaken() {
+	c.read(c.PC)
+	if c.PC&0xff00 == c.addr&0xff00 {
+		c.PC = c.addr
+	} else {
+		c.PC = c.PC&0xff00 | c.addr&0x00ff
+		c.continueWith(branchFixupCycles)
+	}
+}
+
+func (c *Cpu6502) cycBranchFixup() {
+	c.read(c.PC)
+	c.PC = c.addr
+}
+
+func (c *Cpu6502) cycJmpAbsolute()    { c.PC = c.addr | uint16(c.fetch())<<8 }
+func (c *Cpu6502) cycReadPointerLow() { c.data = c.read(c.addr) }
+
+// cycJmpIndirectBug fetches the high byte of the target without carrying into the high byte of
+// the pointer, so JMP ($xxff) reads the high byte from $xx00.
+func (c *Cpu6502) cycJmpIndirectBug() {
+	c.PC = uint16(c.data) | uint16(c.read(c.addr&0xff00|uint16(uint8(c.addr)+1)))<<8
+}
+
+func (c *Cpu6502) cycJmpIndirect() { c.PC = uint16(c.data) | uint16(c.read(c.addr+1))<<8 }
+
+func (c *Cpu6502) cycIndexPointerX() {
+	c.read(c.PC - 1)
+	c.addr += uint16(c.X)
+}
+
+// ================================================================================================
+// Subroutines, the stack and interrupts
+// ================================================================================================
+
+func (c *Cpu6502) cycPushPCH() { c.stackPush(uint8(c.PC >> 8)) }
+func (c *Cpu6502) cycPushPCL() { c.stackPush(uint8(c.PC)) }
+func (c *Cpu6502) cycPopPCL()  { c.PC = c.PC&0xff00 | uint16(c.stackPop()) }
+func (c *Cpu6502) cycPopPCH()  { c.PC = c.PC&0x00ff | uint16(c.stackPop())<<8 }
+func (c *Cpu6502) cycPopP()    { c.plp(0) }
+
+// cycJsr fetches the high byte of the subroutine address. PC still points at it so it is the
+// address of the last byte of the JSR that has been pushed.
+func (c *Cpu6502) cycJsr() { c.PC = c.addr | uint16(c.read(c.PC))<<8 }
+
+func (c *Cpu6502) cycIncrementPC() {
+	c.read(c.PC)
+	c.PC++
+}
+
+// cycBrk reads and skips the signature byte following BRK.
+func (c *Cpu6502) cycBrk() {
+	c.fetch()
+	c.inBrk = true
+	c.vector = 0xfffe
+}
+
+// cycPushP pushes P with the B flag set for BRK so the handler can tell it from an /IRQ. An NMI
+// arriving before this point hijacks an IRQ, or a BRK on the NMOS 6502, which carries on as
+// normal but fetches the NMI vector.
+func (c *Cpu6502) cycPushP() {
+	if c.pendingNmi && c.vector == 0xfffe && (!c.inBrk || c.variant != WDC65C02) {
+		c.vector = 0xfffa
+		c.pendingNmi = false
+	}
+	if c.inBrk {
+		c.stackPush(c.P | uint8(P_BRK_COMMAND) | uint8(P_UNUSED))
+	} else {
+		c.stackPush(c.P&^uint8(P_BRK_COMMAND) | uint8(P_UNUSED))
+	}
+}
+
+// cycVectorLow fetches the low byte of the vector. The interrupt sequence doesn't poll for
+// interrupts, so at least one instruction of the handler runs before another is serviced.
+func (c *Cpu6502) cycVectorLow() {
+	c.addr = uint16(c.read(c.vector))
+	if c.vector == 0xfffe && !c.inBrk {
+		c.pendingIrq = false
+	}
+	c.skipPoll = true
+	c.SetFlag(P_DISABLE_IRQ)
+	if c.variant == WDC65C02 {
+		c.ClearFlag(P_DECIMAL_MODE)
+	}
+}
+
+func (c *Cpu6502) cycVectorHigh() { c.PC = c.addr | uint16(c.read(c.vector+1))<<8 }
@@ -0,0 +1,308 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type busAccess struct {
+	tick  uint64
+	addr  uint16
+	v     uint8
+	write bool
+}
+
+func (a busAccess) String() string {
+	if a.write {
+		return fmt.Sprintf("W $%04x $%02x", a.addr, a.v)
+	}
+	return fmt.Sprintf("R $%04x $%02x", a.addr, a.v)
+}
+
+// recorder is RAM that records every access made to it
+type recorder struct {
+	ram      *Ram
+	bus      *Bus
+	accesses []busAccess
+}
+
+func (r *recorder) Read(addr uint16) uint8 {
+	v := r.ram.Read(addr)
+	r.accesses = append(r.accesses, busAccess{tick: r.bus.TickCount(), addr: addr, v: v})
+	return v
+}
+
+func (r *recorder) Write(addr uint16, v uint8) {
+	r.ram.Write(addr, v)
+	r.accesses = append(r.accesses, busAccess{tick: r.bus.TickCount(), addr: addr, v: v, write: true})
+}
+
+// newRamCpu puts a CPU made by newCpu on a bus with RAM everywhere and program at $0400, ready to
+// run it.
+func newRamCpu(newCpu func(*Bus) *Cpu6502, program ...uint8) (*Bus, *Cpu6502, *Ram) {
+	bus := NewBus()
+	ram := NewRam(0x10000)
+	bus.Addressable(0x0000, 0xffff, ram)
+	for i, b := range program {
+		ram.Write(uint16(0x0400+i), b)
+	}
+	cpu := newCpu(bus)
+	cpu.PC = 0x0400
+	return bus, cpu, ram
+}
+
+func variantCpu(variant Variant) func(*Bus) *Cpu6502 {
+	return func(bus *Bus) *Cpu6502 { return newCpu(bus, variant) }
+}
+
+func newRecordingCpu(variant Variant, program ...uint8) (*Bus, *Cpu6502, *recorder) {
+	bus, cpu, ram := newRamCpu(variantCpu(variant), program...)
+	rec := &recorder{ram: ram, bus: bus}
+	// over the RAM, so only accesses made after the CPU was built are recorded
+	bus.Addressable(0x0000, 0xffff, rec).SetPriority(1)
+	cpu.CycleAccurate = true
+	cpu.P = 0x24
+	return bus, cpu, rec
+}
+
+// step runs a single instruction and returns the number of cycles it took
+func step(bus *Bus, cpu *Cpu6502) int {
+	n := 0
+	for n == 0 || cpu.Waiting() {
+		bus.Tick()
+		n++
+	}
+	return n
+}
+
+var branches = map[string]bool{
+	"BPL": true, "BMI": true, "BVC": true, "BVS": true, "BCC": true, "BCS": true, "BNE": true,
+	"BEQ": true, "BRA": true, "BBR": true, "BBS": true,
+}
+
+func TestCycleCounts(t *testing.T) {
+	for _, variant := range []Variant{NMOS6502, WDC65C02} {
+		opcodes := newCpu(NewBus(), variant).opCodes
+		for i, op := range opcodes {
+			if branches[op.mne[:3]] {
+				// Covered by TestBranchCycles
+				continue
+			}
+			bus, cpu, _ := newRecordingCpu(variant, uint8(i), 0x10, 0x02)
+			if n := step(bus, cpu); n != op.wait {
+				t.Errorf("variant %d opcode $%02x %s took %d cycles not %d", variant, i, op.mne, n, op.wait)
+			}
+		}
+	}
+}
+
+func TestBranchCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		program []uint8
+		cycles  int
+	}{
+		{"not taken", []uint8{0xf0, 0x10}, 2},
+		{"taken", []uint8{0xd0, 0x10}, 3},
+		{"taken across a page", []uint8{0xd0, 0x80}, 4},
+		{"BBR taken", []uint8{0x0f, 0x10, 0x10}, 6},
+		{"BBS not taken", []uint8{0x8f, 0x10, 0x10}, 5},
+	} {
+		bus, cpu, _ := newRecordingCpu(WDC65C02, tc.program...)
+		if n := step(bus, cpu); n != tc.cycles {
+			t.Errorf("branch %s took %d cycles not %d", tc.name, n, tc.cycles)
+		}
+	}
+}
+
+func TestBusAccesses(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		variant  Variant
+		program  []uint8
+		accesses []string
+	}{
+		{
+			name:    "INC abs",
+			variant: NMOS6502,
+			program: []uint8{0xee, 0x10, 0x02},
+			accesses: []string{
+				"R $0400 $ee", "R $0401 $10", "R $0402 $02",
+				"R $0210 $00", "W $0210 $00", "W $0210 $01",
+			},
+		},
+		{
+			name:    "65C02 INC abs",
+			variant: WDC65C02,
+			program: []uint8{0xee, 0x10, 0x02},
+			accesses: []string{
+				"R $0400 $ee", "R $0401 $10", "R $0402 $02",
+				"R $0210 $00", "R $0210 $00", "W $0210 $01",
+			},
+		},
+		{
+			name:    "LDA abs,X across a page",
+			variant: NMOS6502,
+			program: []uint8{0xa2, 0x20, 0xbd, 0xf0, 0x02},
+			accesses: []string{
+				"R $0400 $a2", "R $0401 $20",
+				"R $0402 $bd", "R $0403 $f0", "R $0404 $02", "R $0210 $00", "R $0310 $00",
+			},
+		},
+		{
+			name:    "STA abs,X",
+			variant: NMOS6502,
+			program: []uint8{0x9d, 0x10, 0x02},
+			accesses: []string{
+				"R $0400 $9d", "R $0401 $10", "R $0402 $02", "R $0210 $00", "W $0210 $aa",
+			},
+		},
+		{
+			name:    "JSR",
+			variant: NMOS6502,
+			program: []uint8{0x20, 0x10, 0x02},
+			accesses: []string{
+				"R $0400 $20", "R $0401 $10", "R $01fd $00", "W $01fd $04", "W $01fc $02", "R $0402 $02",
+			},
+		},
+	} {
+		bus, cpu, rec := newRecordingCpu(tc.variant, tc.program...)
+		for cpu.PC >= 0x0400 && cpu.PC < uint16(0x0400+len(tc.program)) {
+			step(bus, cpu)
+		}
+
+		var got []string
+		for i, a := range rec.accesses {
+			if a.tick != uint64(i+1) {
+				t.Errorf("%s: access %v made on cycle %d not %d", tc.name, a, a.tick, i+1)
+			}
+			got = append(got, a.String())
+		}
+		if !reflect.DeepEqual(got, tc.accesses) {
+			t.Errorf("%s: got accesses %v, expected %v", tc.name, got, tc.accesses)
+		}
+	}
+}
+
+func TestFunctionalTestCycleAccurate(t *testing.T) {
+	bus := NewBus()
+	rec := &recorder{ram: NewRam(0x10000), bus: bus}
+	bus.Addressable(0x0000, 0xffff, rec)
+	cpu := NewCpu6502(bus)
+	cpu.CycleAccurate = true
+
+	dat, err := os.ReadFile("functional_tests/6502_functional_test.bin")
+	if err != nil {
+		t.Fatal("Unable to open test binary")
+	}
+	for a, b := range dat {
+		rec.ram.Write(uint16(a), b)
+	}
+
+	cpu.PC = 0x0400
+	pc := cpu.PC
+	for pc != 0x3469 {
+		rec.accesses = rec.accesses[:0]
+		start := bus.TickCount()
+		step(bus, cpu)
+		if n := bus.TickCount() - start; len(rec.accesses) != int(n) {
+			t.Fatalf("instruction at $%04x made %d bus accesses in %d cycles", pc, len(rec.accesses), n)
+		}
+		if pc == cpu.PC {
+			t.Fatalf("Test trapped at $%04x : %s", cpu.PC, cpu.StatusString())
+		}
+		pc = cpu.PC
+	}
+}
+
+func TestWriteCycles(t *testing.T) {
+	for _, variant := range []Variant{NMOS6502, WDC65C02} {
+		opcodes := newCpu(NewBus(), variant).opCodes
+		for i, op := range opcodes {
+			bus, cpu, rec := newRecordingCpu(variant, uint8(i), 0x10, 0x02)
+			cpu.SP = 0xff
+			for n := 0; n == 0 || cpu.Waiting(); n++ {
+				writes := cpu.nextCycleWrites()
+				before := len(rec.accesses)
+				bus.Tick()
+				if len(rec.accesses) != before+1 {
+					continue
+				}
+				if rec.accesses[before].write != writes {
+					t.Errorf("variant %d opcode $%02x %s cycle %d is %v but expected write=%v",
+						variant, i, op.mne, n+1, rec.accesses[before], writes)
+				}
+			}
+		}
+	}
+}
+
+func TestRdy(t *testing.T) {
+	for _, tc := range []struct {
+		variant  Variant
+		accesses []string
+	}{
+		{NMOS6502, []string{
+			"R $0400 $8d @1", "R $0401 $10 @2", "R $0402 $02 @3",
+			"W $0210 $aa @4", "R $0403 $ea @10", "R $0404 $00 @11",
+		}},
+		{WDC65C02, []string{
+			"R $0400 $8d @1", "R $0401 $10 @2", "R $0402 $02 @3",
+			"W $0210 $aa @10", "R $0403 $ea @11",
+		}},
+	} {
+		bus, cpu, rec := newRecordingCpu(tc.variant, 0x8d, 0x10, 0x02, 0xea) // STA $0210, NOP
+		cpu.A = 0xaa
+		tickFor(bus, 3)
+		cpu.RDY.Assert(bus)
+		tickFor(bus, 6)
+		cpu.RDY.Release(bus)
+		tickFor(bus, 2)
+
+		var got []string
+		for _, a := range rec.accesses {
+			got = append(got, fmt.Sprintf("%v @%d", a, a.tick))
+		}
+		if strings.Join(got, ", ") != strings.Join(tc.accesses, ", ") {
+			t.Errorf("variant %d made accesses\n%v\nexpected\n%v", tc.variant, got, tc.accesses)
+		}
+	}
+}
+
+func TestSo(t *testing.T) {
+	bus, cpu, _ := newRecordingCpu(NMOS6502, 0xb8, 0xb8, 0xb8) // CLV, CLV, CLV
+	cpu.SO.Assert(bus)
+	bus.Tick()
+	if !cpu.FlagSet(P_OVERFLOW) {
+		t.Fatal("SO did not set V")
+	}
+	tickFor(bus, 2)
+	if cpu.FlagSet(P_OVERFLOW) {
+		t.Fatal("SO held low set V again")
+	}
+	cpu.SO.Release(bus)
+	bus.Tick()
+	cpu.SO.Assert(bus)
+	bus.Tick()
+	if !cpu.FlagSet(P_OVERFLOW) {
+		t.Error("second falling edge on SO did not set V")
+	}
+}
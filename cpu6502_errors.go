@@ -0,0 +1,44 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import "fmt"
+
+// InvalidOpcodePolicy decides what the CPU does when it fetches an opcode it has no
+// implementation for.
+type InvalidOpcodePolicy int
+
+const (
+	// InvalidOpcodeHalt leaves PC on the offending opcode and returns an *InvalidOpcodeError from
+	// every Tick until the CPU is Reset or PC is moved.
+	InvalidOpcodeHalt InvalidOpcodePolicy = iota
+	// InvalidOpcodeNop treats the opcode as a single byte, two cycle NOP.
+	InvalidOpcodeNop
+	// InvalidOpcodeTrap passes the error to Cpu6502.InvalidOpcodeHandler. If the handler returns
+	// nil execution continues as for InvalidOpcodeNop, otherwise the returned error is passed up.
+	InvalidOpcodeTrap
+)
+
+// InvalidOpcodeError is returned from Tick when the CPU fetches an unimplemented opcode.
+type InvalidOpcodeError struct {
+	Opcode    uint8
+	Addr      uint16
+	Registers Registers // Register state when the opcode was fetched
+}
+
+func (e *InvalidOpcodeError) Error() string {
+	return fmt.Sprintf("invalid opcode $%02x at $%04x", e.Opcode, e.Addr)
+}
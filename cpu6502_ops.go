@@ -20,33 +20,33 @@ package munch
 // ================================================================================================
 
 func (cpu *Cpu6502) lda(addr uint16) {
-	cpu.A = cpu.bus.Read(addr)
+	cpu.A = cpu.read(addr)
 	cpu.testAndSetNegative(cpu.A)
 	cpu.testAndSetZero(cpu.A)
 }
 
 func (cpu *Cpu6502) ldx(addr uint16) {
-	cpu.X = cpu.bus.Read(addr)
+	cpu.X = cpu.read(addr)
 	cpu.testAndSetNegative(cpu.X)
 	cpu.testAndSetZero(cpu.X)
 }
 
 func (cpu *Cpu6502) ldy(addr uint16) {
-	cpu.Y = cpu.bus.Read(addr)
+	cpu.Y = cpu.read(addr)
 	cpu.testAndSetNegative(cpu.Y)
 	cpu.testAndSetZero(cpu.Y)
 }
 
 func (cpu *Cpu6502) sta(addr uint16) {
-	cpu.bus.Write(addr, cpu.A)
+	cpu.write(addr, cpu.A)
 }
 
 func (cpu *Cpu6502) stx(addr uint16) {
-	cpu.bus.Write(addr, cpu.X)
+	cpu.write(addr, cpu.X)
 }
 
 func (cpu *Cpu6502) sty(addr uint16) {
-	cpu.bus.Write(addr, cpu.Y)
+	cpu.write(addr, cpu.Y)
 }
 
 // ================================================================================================
@@ -54,7 +54,10 @@ func (cpu *Cpu6502) sty(addr uint16) {
 // ================================================================================================
 
 func (cpu *Cpu6502) adc(addr uint16) {
-	v := cpu.bus.Read(addr)
+	cpu.addWithCarry(cpu.read(addr))
+}
+
+func (cpu *Cpu6502) addWithCarry(v uint8) {
 	c := uint8(0)
 	if cpu.FlagSet(P_CARRY) {
 		c = 1
@@ -77,6 +80,7 @@ func (cpu *Cpu6502) adc(addr uint16) {
 		cpu.SetFlagValue(P_CARRY, h&0xff00 != 0)
 
 		cpu.A = uint8(l&0xf) | uint8(h&0xf0)
+		cpu.fixDecimalFlags()
 	} else {
 		t := uint16(cpu.A) + uint16(v) + uint16(c)
 
@@ -97,7 +101,10 @@ func (cpu *Cpu6502) adc(addr uint16) {
 }
 
 func (cpu *Cpu6502) sbc(addr uint16) {
-	v := cpu.bus.Read(addr)
+	cpu.subtractWithCarry(cpu.read(addr))
+}
+
+func (cpu *Cpu6502) subtractWithCarry(v uint8) {
 	c := uint16(1)
 	if cpu.FlagSet(P_CARRY) {
 		c = 0
@@ -120,7 +127,20 @@ func (cpu *Cpu6502) sbc(addr uint16) {
 			h -= 0x60
 		}
 
-		cpu.A = uint8(l&0x0f) | uint8(h&0xf0)
+		if cpu.variant == WDC65C02 {
+			// The 65C02 adjusts the binary difference instead, which only differs for invalid BCD
+			d := t
+			if t&0xff00 != 0 {
+				d -= 0x60
+			}
+			if uint16(cpu.A&0x0f) < uint16(v&0x0f)+c {
+				d -= 0x06
+			}
+			cpu.A = uint8(d)
+		} else {
+			cpu.A = uint8(l&0x0f) | uint8(h&0xf0)
+		}
+		cpu.fixDecimalFlags()
 	} else {
 		cpu.SetFlagValue(P_OVERFLOW, (cpu.A^v)&(cpu.A^uint8(t))&0x80 != 0)
 		cpu.SetFlagValue(P_CARRY, t&0xff00 == 0)
@@ -130,15 +150,23 @@ func (cpu *Cpu6502) sbc(addr uint16) {
 	}
 }
 
+// fixDecimalFlags sets N and Z from the decimal result on the 65C02 which, unlike the NMOS part,
+// takes an extra cycle to get them right.
+func (cpu *Cpu6502) fixDecimalFlags() {
+	if cpu.variant == WDC65C02 {
+		cpu.testAndSetNZ(cpu.A)
+		cpu.continueWith(decimalFixupCycles)
+	}
+}
+
 // ================================================================================================
 // Increment and Decrement Instructions
 // ================================================================================================
 
-func (cpu *Cpu6502) inc(addr uint16) {
-	v := cpu.bus.Read(addr) + 1
-	cpu.bus.Write(addr, v)
-
+func (cpu *Cpu6502) inc(v uint8) uint8 {
+	v += 1
 	cpu.testAndSetNZ(v)
+	return v
 }
 
 func (cpu *Cpu6502) inx(addr uint16) {
@@ -151,11 +179,10 @@ func (cpu *Cpu6502) iny(addr uint16) {
 	cpu.testAndSetNZ(cpu.Y)
 }
 
-func (cpu *Cpu6502) dec(addr uint16) {
-	v := cpu.bus.Read(addr) - 1
-	cpu.bus.Write(addr, v)
-
+func (cpu *Cpu6502) dec(v uint8) uint8 {
+	v -= 1
 	cpu.testAndSetNZ(v)
+	return v
 }
 
 func (cpu *Cpu6502) dex(addr uint16) {
@@ -172,37 +199,29 @@ func (cpu *Cpu6502) dey(addr uint16) {
 // Shift and Rotate Instructions
 // ================================================================================================
 
-func (cpu *Cpu6502) asl(addr uint16) {
-	v := cpu.bus.Read(addr)
+func (cpu *Cpu6502) asl(v uint8) uint8 {
 	cpu.SetFlagValue(P_CARRY, v&0x80 == 0x80)
-	cpu.bus.Write(addr, v<<1)
-	v = cpu.bus.Read(addr)
+	v <<= 1
 	cpu.testAndSetNZ(v)
+	return v
 }
 
 func (cpu *Cpu6502) aslAcc(a uint16) {
-	cpu.SetFlagValue(P_CARRY, cpu.A&0x80 == 0x80)
-	cpu.A <<= 1
-	cpu.testAndSetNZ(cpu.A)
+	cpu.A = cpu.asl(cpu.A)
 }
 
-func (cpu *Cpu6502) lsr(addr uint16) {
-	v := cpu.bus.Read(addr)
+func (cpu *Cpu6502) lsr(v uint8) uint8 {
 	cpu.SetFlagValue(P_CARRY, v&0x01 == 0x01)
-	cpu.bus.Write(addr, v>>1)
-	v = cpu.bus.Read(addr)
+	v >>= 1
 	cpu.testAndSetNZ(v)
+	return v
 }
 
 func (cpu *Cpu6502) lsrAcc(a uint16) {
-	cpu.SetFlagValue(P_CARRY, cpu.A&0x01 == 0x01)
-	cpu.A >>= 1
-	cpu.testAndSetNZ(cpu.A)
+	cpu.A = cpu.lsr(cpu.A)
 }
 
-func (cpu *Cpu6502) rol(addr uint16) {
-	v := cpu.bus.Read(addr)
-
+func (cpu *Cpu6502) rol(v uint8) uint8 {
 	carry := v&0x80 == 0x80
 
 	v <<= 1
@@ -211,29 +230,16 @@ func (cpu *Cpu6502) rol(addr uint16) {
 		v += 1
 	}
 
-	cpu.bus.Write(addr, v)
-	v = cpu.bus.Read(addr)
-
 	cpu.SetFlagValue(P_CARRY, carry)
 	cpu.testAndSetNZ(v)
+	return v
 }
 
 func (cpu *Cpu6502) rolAcc(a uint16) {
-	carry := cpu.A&0x80 == 0x80
-
-	cpu.A <<= 1
-
-	if cpu.FlagSet(P_CARRY) {
-		cpu.A += 1
-	}
-
-	cpu.SetFlagValue(P_CARRY, carry)
-	cpu.testAndSetNZ(cpu.A)
+	cpu.A = cpu.rol(cpu.A)
 }
 
-func (cpu *Cpu6502) ror(addr uint16) {
-	v := cpu.bus.Read(addr)
-
+func (cpu *Cpu6502) ror(v uint8) uint8 {
 	carry := v&0x01 == 0x01
 
 	v >>= 1
@@ -242,35 +248,13 @@ func (cpu *Cpu6502) ror(addr uint16) {
 		v += 0x80
 	}
 
-	if carry {
-		cpu.SetFlag(P_CARRY)
-	} else {
-		cpu.ClearFlag(P_CARRY)
-	}
-
-	cpu.bus.Write(addr, v)
-	v = cpu.bus.Read(addr)
-
-	cpu.testAndSetNegative(v)
-	cpu.testAndSetZero(v)
+	cpu.SetFlagValue(P_CARRY, carry)
+	cpu.testAndSetNZ(v)
+	return v
 }
-func (cpu *Cpu6502) rorAcc(a uint16) {
-	carry := cpu.A&0x01 == 0x01
-
-	cpu.A >>= 1
 
-	if cpu.FlagSet(P_CARRY) {
-		cpu.A += 0x80
-	}
-
-	if carry {
-		cpu.SetFlag(P_CARRY)
-	} else {
-		cpu.ClearFlag(P_CARRY)
-	}
-
-	cpu.testAndSetNegative(cpu.A)
-	cpu.testAndSetZero(cpu.A)
+func (cpu *Cpu6502) rorAcc(a uint16) {
+	cpu.A = cpu.ror(cpu.A)
 }
 
 // ================================================================================================
@@ -278,17 +262,17 @@ func (cpu *Cpu6502) rorAcc(a uint16) {
 // ================================================================================================
 
 func (cpu *Cpu6502) and(addr uint16) {
-	cpu.A &= cpu.bus.Read(addr)
+	cpu.A &= cpu.read(addr)
 	cpu.testAndSetNZ(cpu.A)
 }
 
 func (cpu *Cpu6502) ora(addr uint16) {
-	cpu.A |= cpu.bus.Read(addr)
+	cpu.A |= cpu.read(addr)
 	cpu.testAndSetNZ(cpu.A)
 }
 
 func (cpu *Cpu6502) eor(addr uint16) {
-	cpu.A ^= cpu.bus.Read(addr)
+	cpu.A ^= cpu.read(addr)
 	cpu.testAndSetNZ(cpu.A)
 }
 
@@ -303,19 +287,19 @@ func (cpu *Cpu6502) compare(a, b uint8) {
 }
 
 func (cpu *Cpu6502) cmp(addr uint16) {
-	cpu.compare(cpu.A, cpu.bus.Read(addr))
+	cpu.compare(cpu.A, cpu.read(addr))
 }
 
 func (cpu *Cpu6502) cpx(addr uint16) {
-	cpu.compare(cpu.X, cpu.bus.Read(addr))
+	cpu.compare(cpu.X, cpu.read(addr))
 }
 
 func (cpu *Cpu6502) cpy(addr uint16) {
-	cpu.compare(cpu.Y, cpu.bus.Read(addr))
+	cpu.compare(cpu.Y, cpu.read(addr))
 }
 
 func (cpu *Cpu6502) bit(addr uint16) {
-	v := cpu.bus.Read(addr)
+	v := cpu.read(addr)
 
 	cpu.testAndSetZero(v & cpu.A)
 	cpu.testAndSetNegative(v)
@@ -325,65 +309,52 @@ func (cpu *Cpu6502) bit(addr uint16) {
 // ================================================================================================
 // Branch Instructions
 // ================================================================================================
-func (cpu *Cpu6502) addPageBoundaryCycles(a, b uint16) {
-	if a&0xff00 != b&0xff00 {
-		cpu.waitCycles += 1
-	}
-}
 
 func (cpu *Cpu6502) bcc(addr uint16) {
 	if !cpu.FlagSet(P_CARRY) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) bcs(addr uint16) {
 	if cpu.FlagSet(P_CARRY) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) bne(addr uint16) {
 	if cpu.P&0x02 == 0x00 {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) beq(addr uint16) {
 	if cpu.FlagSet(P_ZERO) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) bpl(addr uint16) {
 	if !cpu.FlagSet(P_NEGATIVE) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) bmi(addr uint16) {
 	if cpu.FlagSet(P_NEGATIVE) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) bvc(addr uint16) {
 	if !cpu.FlagSet(P_OVERFLOW) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
 func (cpu *Cpu6502) bvs(addr uint16) {
 	if cpu.FlagSet(P_OVERFLOW) {
-		cpu.addPageBoundaryCycles(cpu.PC-1, addr)
-		cpu.PC = addr
+		cpu.branch(addr)
 	}
 }
 
@@ -443,27 +414,7 @@ func (cpu *Cpu6502) plp(a uint16) {
 	cpu.P = cpu.stackPop() | uint8(P_BRK_COMMAND) | uint8(P_UNUSED)
 }
 
-// ================================================================================================
-// Subroutines and Jump Instructions
-// ================================================================================================
-
-func (cpu *Cpu6502) jmp(addr uint16) {
-	cpu.PC = addr
-}
-
-func (cpu *Cpu6502) jsr(addr uint16) {
-	cpu.stackPushWord(cpu.PC - 1)
-	cpu.PC = addr
-}
-
-func (cpu *Cpu6502) rts(addr uint16) {
-	cpu.PC = cpu.stackPopWord() + 1
-}
-
-func (cpu *Cpu6502) rti(addr uint16) {
-	cpu.plp(addr)
-	cpu.PC = cpu.stackPopWord()
-}
+// JMP, JSR, RTS and RTI are implemented entirely by their cycles, see cpu6502_cycles.go.
 
 // ================================================================================================
 // Set and Clear Instructions
@@ -481,13 +432,7 @@ func (cpu *Cpu6502) clv(addr uint16) { cpu.ClearFlag(P_OVERFLOW) }
 // Miscellaneous Instructions
 // ================================================================================================
 
-func (cpu *Cpu6502) brk(a uint16) {
-	cpu.PC += 1
-	cpu.stackPushWord(cpu.PC)
-	cpu.php(a)
-	cpu.sei(a)
-	cpu.PC = readWord(cpu.bus, 0xfffe)
-}
+// BRK shares its cycles with the interrupt sequence, see cpu6502_cycles.go.
 
 func (cpu *Cpu6502) nop(addr uint16) {}
 
@@ -495,14 +440,19 @@ func (cpu *Cpu6502) nop(addr uint16) {}
 // Unofficial Instructions
 // ================================================================================================
 
-func (c *Cpu6502) slo(a uint16) { c.asl(a); c.ora(a) }
-func (c *Cpu6502) rla(a uint16) { c.rol(a); c.and(a) }
-func (c *Cpu6502) sre(a uint16) { c.lsr(a); c.eor(a) }
-func (c *Cpu6502) rra(a uint16) { c.ror(a); c.adc(a) }
-func (c *Cpu6502) sax(a uint16) { c.bus.Write(a, c.A&c.X) }
-func (c *Cpu6502) lax(a uint16) { c.lda(a); c.ldx(a) }
-func (c *Cpu6502) dcp(a uint16) { c.dec(a); c.cmp(a) }
-func (c *Cpu6502) isc(a uint16) { c.inc(a); c.sbc(a) }
+func (c *Cpu6502) slo(v uint8) uint8 { v = c.asl(v); c.A |= v; c.testAndSetNZ(c.A); return v }
+func (c *Cpu6502) rla(v uint8) uint8 { v = c.rol(v); c.A &= v; c.testAndSetNZ(c.A); return v }
+func (c *Cpu6502) sre(v uint8) uint8 { v = c.lsr(v); c.A ^= v; c.testAndSetNZ(c.A); return v }
+func (c *Cpu6502) rra(v uint8) uint8 { v = c.ror(v); c.addWithCarry(v); return v }
+func (c *Cpu6502) dcp(v uint8) uint8 { v = c.dec(v); c.compare(c.A, v); return v }
+func (c *Cpu6502) isc(v uint8) uint8 { v = c.inc(v); c.subtractWithCarry(v); return v }
+func (c *Cpu6502) sax(a uint16)      { c.write(a, c.A&c.X) }
+
+func (cpu *Cpu6502) lax(addr uint16) {
+	cpu.A = cpu.read(addr)
+	cpu.X = cpu.A
+	cpu.testAndSetNZ(cpu.A)
+}
 
 func (cpu *Cpu6502) anc(addr uint16) {
 	cpu.and(addr)
@@ -517,7 +467,7 @@ func (cpu *Cpu6502) alr(addr uint16) {
 func (cpu *Cpu6502) arr(addr uint16) {
 	// ARR is weird see http://www.cs.cmu.edu/~dsladic/vice/doc/64doc.txt
 	if cpu.FlagSet(P_DECIMAL_MODE) && !cpu.DisableDecimal {
-		s := cpu.bus.Read(addr)
+		s := cpu.read(addr)
 		t := cpu.A & s /* Perform the AND. */
 
 		AH := t >> 4 /* Separate the high */
@@ -561,30 +511,53 @@ func (cpu *Cpu6502) arr(addr uint16) {
 }
 
 func (cpu *Cpu6502) axs(addr uint16) {
-	data := cpu.bus.Read(addr)
+	data := cpu.read(addr)
 	lhs := cpu.A & cpu.X
 	cpu.X = lhs - data
 	cpu.compare(lhs, data)
 }
 
-func (c *Cpu6502) shy(a uint16) {
-	high := (a & 0xff00) >> 8
-	a &= 0x00ff
-	if a+uint16(c.X) > 0x00ff {
-		a += (((high & uint16(c.Y)) << 8) + uint16(c.X))
-	} else {
-		a += ((high << 8) + uint16(c.X))
+// shStore implements the store half of SHA, SHX, SHY and TAS. The value written is ANDed with
+// the high byte of the base address plus one and, if indexing crossed a page, the written value
+// also replaces the high byte of the target address.
+func (c *Cpu6502) shStore(addr uint16, v uint8) {
+	v &= uint8(c.base>>8) + 1
+	if addr&0xff00 != c.base&0xff00 {
+		addr = uint16(v)<<8 | addr&0x00ff
 	}
-	c.bus.Write(a, c.Y&(uint8(high)+1))
+	c.write(addr, v)
 }
 
-func (c *Cpu6502) shx(a uint16) {
-	high := (a & 0xff00) >> 8
-	a &= 0x00ff
-	if a+uint16(c.Y) > 0x00ff {
-		a += (((high & uint16(c.X)) << 8) + uint16(c.Y))
-	} else {
-		a += ((high << 8) + uint16(c.Y))
-	}
-	c.bus.Write(a, c.X&(uint8(high)+1))
+func (c *Cpu6502) shy(a uint16) { c.shStore(a, c.Y) }
+func (c *Cpu6502) shx(a uint16) { c.shStore(a, c.X) }
+func (c *Cpu6502) sha(a uint16) { c.shStore(a, c.A&c.X) }
+
+func (c *Cpu6502) tas(a uint16) {
+	c.SP = c.A & c.X
+	c.shStore(a, c.SP)
+}
+
+func (cpu *Cpu6502) las(addr uint16) {
+	v := cpu.read(addr) & cpu.SP
+	cpu.A, cpu.X, cpu.SP = v, v, v
+	cpu.testAndSetNZ(v)
+}
+
+// ANE and LXA mix in a chip dependent "magic" constant, see UnstableModel.
+
+func (cpu *Cpu6502) ane(addr uint16) {
+	cpu.A = (cpu.A | cpu.Unstable.AneMagic) & cpu.X & cpu.read(addr)
+	cpu.testAndSetNZ(cpu.A)
+}
+
+func (cpu *Cpu6502) lxa(addr uint16) {
+	cpu.A = (cpu.A | cpu.Unstable.LxaMagic) & cpu.read(addr)
+	cpu.X = cpu.A
+	cpu.testAndSetNZ(cpu.A)
+}
+
+// jam locks up the processor until it is reset.
+func (cpu *Cpu6502) jam(addr uint16) {
+	cpu.PC--
+	cpu.halted = true
 }
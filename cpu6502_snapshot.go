@@ -0,0 +1,180 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import "fmt"
+
+// namedCycles lets the cycles of an instruction in progress be saved by name. Cycles that belong
+// to the current opcode are saved as "op".
+var namedCycles = map[string][]cycle{
+	"fetch":        fetchCycles,
+	"interrupt":    interruptCycles,
+	"brk":          brkCycles,
+	"jsr":          jsrCycles,
+	"rts":          rtsCycles,
+	"rti":          rtiCycles,
+	"jmpAbs":       jmpAbsCycles,
+	"jmpInd":       jmpIndCycles,
+	"jmpIndFixed":  jmpIndFixedCycles,
+	"jmpIax":       jmpIaxCycles,
+	"push":         pushCycles,
+	"pull":         pullCycles,
+	"waiStp":       waiStpCycles,
+	"nop5c":        nop5cCycles,
+	"invalidNop":   invalidNopCycles,
+	"branchTaken":  branchTakenCycles,
+	"branchFixup":  branchFixupCycles,
+	"decimalFixup": decimalFixupCycles,
+}
+
+func sameCycles(a, b []cycle) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
+
+type cpuSnapshot struct {
+	Variant       Variant
+	Registers     Registers
+	WaitCycles    int
+	Instructions  uint64
+	PendingIrq    bool
+	PendingNmi    bool
+	NmiLevel      bool
+	SoLevel       bool
+	Polled        bool
+	IrqEnabled    bool
+	InterruptNext bool
+	SkipPoll      bool
+	Halted        bool
+	Waiting       bool
+
+	// The instruction in progress
+	Opcode int // -1 if there isn't one
+	Cycles string
+	Step   int
+	OpAddr uint16
+	Addr   uint16
+	Base   uint16
+	Ptr    uint8
+	Data   uint8
+	Vector uint16
+	InBrk  bool
+
+	Port *ioPortSnapshot // the 6510's I/O port
+}
+
+// Snapshot saves the state of the CPU, including an instruction part way through in cycle
+// accurate mode. Options such as CycleAccurate and Unstable are not saved.
+func (cpu *Cpu6502) Snapshot() ([]byte, error) {
+	s := cpuSnapshot{
+		Variant:       cpu.variant,
+		Registers:     cpu.Registers(),
+		WaitCycles:    cpu.waitCycles,
+		Instructions:  cpu.instructions,
+		PendingIrq:    cpu.pendingIrq,
+		PendingNmi:    cpu.pendingNmi,
+		NmiLevel:      cpu.nmiLevel,
+		SoLevel:       cpu.soLevel,
+		Polled:        cpu.polled,
+		IrqEnabled:    cpu.irqEnabled,
+		InterruptNext: cpu.interruptNext,
+		SkipPoll:      cpu.skipPoll,
+		Halted:        cpu.halted,
+		Waiting:       cpu.waiting,
+		Opcode:        -1,
+		Step:          cpu.step,
+		OpAddr:        cpu.opAddr,
+		Addr:          cpu.addr,
+		Base:          cpu.base,
+		Ptr:           cpu.ptr,
+		Data:          cpu.data,
+		Vector:        cpu.vector,
+		InBrk:         cpu.inBrk,
+	}
+	for i, op := range cpu.opCodes {
+		if op != nil && op == cpu.op {
+			s.Opcode = i
+		}
+	}
+	if cpu.step < len(cpu.cycles) {
+		if cpu.op != nil && sameCycles(cpu.cycles, cpu.op.cycles) {
+			s.Cycles = "op"
+		} else {
+			for name, cycles := range namedCycles {
+				if sameCycles(cpu.cycles, cycles) {
+					s.Cycles = name
+				}
+			}
+			if s.Cycles == "" {
+				return nil, fmt.Errorf("cannot snapshot the cycles of the instruction at $%04x", cpu.opAddr)
+			}
+		}
+	}
+	if cpu.Port != nil {
+		s.Port = cpu.Port.snapshot()
+	}
+	return encodeSnapshot(s)
+}
+
+func (cpu *Cpu6502) Restore(data []byte) error {
+	var s cpuSnapshot
+	if err := decodeSnapshot(data, &s); err != nil {
+		return err
+	}
+	if s.Variant != cpu.variant {
+		return fmt.Errorf("%w: CPU variant %d where the snapshot has %d", ErrSnapshotMismatch, cpu.variant, s.Variant)
+	}
+
+	var op *opcode
+	if s.Opcode >= 0 && s.Opcode < len(cpu.opCodes) {
+		op = cpu.opCodes[s.Opcode]
+	}
+	var cycles []cycle
+	switch s.Cycles {
+	case "":
+	case "op":
+		if op == nil {
+			return fmt.Errorf("%w: no opcode $%02x", ErrSnapshotMismatch, s.Opcode)
+		}
+		cycles = op.cycles
+	default:
+		var ok bool
+		if cycles, ok = namedCycles[s.Cycles]; !ok {
+			return fmt.Errorf("%w: unknown cycles %q", ErrSnapshotMismatch, s.Cycles)
+		}
+	}
+
+	r := s.Registers
+	cpu.A, cpu.X, cpu.Y, cpu.SP, cpu.P, cpu.PC = r.A, r.X, r.Y, r.SP, r.P, r.PC
+	cpu.waitCycles = s.WaitCycles
+	cpu.instructions = s.Instructions
+	cpu.pendingIrq, cpu.pendingNmi, cpu.nmiLevel = s.PendingIrq, s.PendingNmi, s.NmiLevel
+	cpu.soLevel = s.SoLevel
+	cpu.polled, cpu.irqEnabled = s.Polled, s.IrqEnabled
+	cpu.interruptNext, cpu.skipPoll = s.InterruptNext, s.SkipPoll
+	cpu.halted, cpu.waiting = s.Halted, s.Waiting
+	cpu.op, cpu.cycles, cpu.step = op, cycles, s.Step
+	if cycles == nil {
+		cpu.step = 0
+	}
+	cpu.opAddr, cpu.addr, cpu.base = s.OpAddr, s.Addr, s.Base
+	cpu.ptr, cpu.data, cpu.vector, cpu.inBrk = s.Ptr, s.Data, s.Vector, s.InBrk
+	cpu.err = nil
+	cpu.tracing = false
+	if cpu.Port != nil && s.Port != nil {
+		cpu.Port.restore(s.Port)
+	}
+	return nil
+}
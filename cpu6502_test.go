@@ -0,0 +1,205 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestCpu(rom []uint8) (*Bus, *Cpu6502) {
+	bus := NewBus()
+	bus.Addressable(0x0000, 0x05ff, NewRam(0x0600))
+	bus.Addressable(0x0600, uint16(0x0600+len(rom)-1), NewRom(rom))
+	bus.Addressable(0xfffa, 0xffff, NewRom([]uint8{0x00, 0x00, 0x00, 0x06, 0x00, 0x05}))
+
+	return bus, NewCpu6502(bus)
+}
+
+func TestInvalidOpcodeHalts(t *testing.T) {
+	bus, cpu := newTestCpu([]uint8{0xea, 0x02, 0xea})
+	cpu.opCodes[0x02] = nil
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = bus.Tick()
+	}
+
+	var opErr *InvalidOpcodeError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("expected *InvalidOpcodeError, got %v", err)
+	}
+	if opErr.Opcode != 0x02 || opErr.Addr != 0x0601 {
+		t.Errorf("unexpected error contents %+v", opErr)
+	}
+	if cpu.PC != 0x0601 {
+		t.Errorf("PC is $%04x not $0601", cpu.PC)
+	}
+	if err := bus.Tick(); err == nil {
+		t.Error("halted CPU continued executing")
+	}
+}
+
+func TestInvalidOpcodeNop(t *testing.T) {
+	bus, cpu := newTestCpu([]uint8{0x02, 0xa9, 0x42})
+	cpu.opCodes[0x02] = nil
+	cpu.InvalidOpcodePolicy = InvalidOpcodeNop
+
+	for cpu.PC != 0x0603 {
+		if err := bus.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpu.A != 0x42 {
+		t.Errorf("A is $%02x not $42", cpu.A)
+	}
+}
+
+func TestInvalidOpcodeTrap(t *testing.T) {
+	bus, cpu := newTestCpu([]uint8{0x02, 0x02})
+	cpu.opCodes[0x02] = nil
+	cpu.InvalidOpcodePolicy = InvalidOpcodeTrap
+
+	stop := errors.New("stop")
+	var trapped []uint16
+	cpu.InvalidOpcodeHandler = func(err *InvalidOpcodeError) error {
+		trapped = append(trapped, err.Addr)
+		if len(trapped) == 2 {
+			return stop
+		}
+		return nil
+	}
+
+	var err error
+	for err == nil {
+		err = bus.Tick()
+	}
+	if err != stop {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if len(trapped) != 2 || trapped[0] != 0x0600 || trapped[1] != 0x0601 {
+		t.Errorf("unexpected traps %v", trapped)
+	}
+	if cpu.PC != 0x0601 {
+		t.Errorf("PC is $%04x after the handler stopped at $0601", cpu.PC)
+	}
+}
+
+func TestDisassembleInvalidOpcode(t *testing.T) {
+	_, cpu := newTestCpu([]uint8{0x02})
+	cpu.opCodes[0x02] = nil
+
+	asm, n := cpu.Disassemble(0x0600)
+	if asm != ".byte $02" || n != 1 {
+		t.Errorf("got %q, %d", asm, n)
+	}
+}
+
+func TestJamHaltsUntilReset(t *testing.T) {
+	bus, cpu := newTestCpu([]uint8{0xe8, 0x02, 0xe8})
+
+	for i := 0; i < 20; i++ {
+		if err := bus.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !cpu.Halted() {
+		t.Fatal("CPU did not halt")
+	}
+	if cpu.PC != 0x0601 || cpu.X != 1 {
+		t.Errorf("unexpected state after JAM %s", cpu.StatusString())
+	}
+
+	cpu.Irq()
+	cpu.Nmi()
+	bus.Tick()
+	if cpu.PC != 0x0601 {
+		t.Errorf("halted CPU serviced an interrupt")
+	}
+
+	cpu.Reset()
+	if cpu.Halted() {
+		t.Error("Reset did not clear the halted state")
+	}
+}
+
+func TestUnstableMagic(t *testing.T) {
+	rom := []uint8{
+		0xa9, 0x00, //       LDA #$00
+		0xa2, 0xff, //       LDX #$ff
+		0x8b, 0xff, //       ANE #$ff
+		0x85, 0x00, //       STA $00
+		0xa9, 0x00, //       LDA #$00
+		0xab, 0xff, //       LXA #$ff
+	}
+	bus, cpu := newTestCpu(rom)
+	cpu.Unstable = UnstableModel{AneMagic: 0x12, LxaMagic: 0x34}
+
+	for cpu.PC != uint16(0x0600+len(rom)) {
+		bus.Tick()
+	}
+	if v := bus.Read(0x00); v != 0x12 {
+		t.Errorf("ANE result $%02x not $12", v)
+	}
+	if cpu.A != 0x34 || cpu.X != 0x34 {
+		t.Errorf("LXA result A:$%02x X:$%02x not $34", cpu.A, cpu.X)
+	}
+}
+
+func TestLas(t *testing.T) {
+	rom := []uint8{
+		0xa9, 0xf0, //       LDA #$f0
+		0x85, 0x10, //       STA $10
+		0xa0, 0x10, //       LDY #$10
+		0xbb, 0x00, 0x00, // LAS $0000,Y
+	}
+	bus, cpu := newTestCpu(rom)
+	cpu.SP = 0x3c
+
+	for cpu.PC != uint16(0x0600+len(rom)) {
+		bus.Tick()
+	}
+	if cpu.A != 0x30 || cpu.X != 0x30 || cpu.SP != 0x30 {
+		t.Errorf("unexpected LAS result %s", cpu.StatusString())
+	}
+}
+
+func TestShaPageCrossing(t *testing.T) {
+	rom := []uint8{
+		0xa9, 0xff, //       LDA #$ff
+		0xa2, 0x03, //       LDX #$03
+		0xa0, 0x01, //       LDY #$01
+		0x9f, 0x40, 0x01, // SHA $0140,Y
+		0xa0, 0xc0, //       LDY #$c0
+		0x9b, 0x40, 0x01, // TAS $0140,Y
+	}
+	bus, cpu := newTestCpu(rom)
+
+	for cpu.PC != uint16(0x0600+len(rom)) {
+		bus.Tick()
+	}
+	// No page crossing so the value is A & X & (H+1)
+	if v := bus.Read(0x0141); v != 0x02 {
+		t.Errorf("SHA wrote $%02x not $02", v)
+	}
+	// Page crossed so the value also becomes the high byte of the target
+	if v := bus.Read(0x0200); v != 0x02 {
+		t.Errorf("TAS wrote $%02x not $02", v)
+	}
+	if cpu.SP != 0x03 {
+		t.Errorf("TAS set SP to $%02x not $03", cpu.SP)
+	}
+}
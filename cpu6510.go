@@ -0,0 +1,139 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// ioPortFadeCycles is roughly how long an undriven pin of a Commodore 64's 6510 holds a 1 after
+// it stops being an output.
+const ioPortFadeCycles = 350000
+
+// NewCpu6510 creates a MOS 6510, an NMOS 6502 with an I/O port at $0000 and $0001.
+func NewCpu6510(bus *Bus) *Cpu6502 {
+	cpu := newCpu(bus, MOS6510)
+	cpu.Unstable = UnstableModel6510
+	return cpu
+}
+
+// IOPort is the 6510's on-chip I/O port. $0000 is the data direction register, where a set bit
+// makes the pin an output, and $0001 is the data register. Reading the data register gives the
+// level on each pin: the data register for outputs, Input for inputs driven by external hardware
+// and for undriven inputs whatever charge is left from when they were last outputs. An undriven
+// pin that was left high fades to low after FadeCycles cycles.
+type IOPort struct {
+	Input      uint8 // levels driven onto input pins by external hardware
+	Driven     uint8 // pins external hardware drives, the rest float when they are inputs
+	FadeCycles uint64
+
+	// OnChange is called with the level of the pins whenever they change, so banking logic can
+	// follow the port. Changes made by a write, a reset or a restore are seen straight away, but a
+	// pin fading or a change to Input is only seen on the next access to the port.
+	OnChange func(pins uint8)
+
+	direction uint8
+	data      uint8
+	fading    uint8     // floating pins still holding a 1
+	fadeAt    [8]uint64 // the tick each fading pin falls to 0
+	pins      uint8     // as last passed to OnChange
+
+	bus *Bus
+}
+
+func newIOPort(bus *Bus) *IOPort {
+	return &IOPort{FadeCycles: ioPortFadeCycles, bus: bus}
+}
+
+func (p *IOPort) reset() {
+	p.direction = 0
+	p.data = 0
+	p.fading = 0
+	p.update()
+}
+
+func (p *IOPort) Direction() uint8 { return p.direction }
+func (p *IOPort) Data() uint8      { return p.data }
+
+// Pins returns the level on each of the port's pins.
+func (p *IOPort) Pins() uint8 {
+	v := p.data&p.direction | p.Input&p.Driven&^p.direction
+	floating := p.fading &^ p.direction &^ p.Driven
+	if floating != 0 {
+		now := p.bus.TickCount()
+		for bit := 0; bit < 8; bit++ {
+			if floating&(1<<bit) != 0 && now < p.fadeAt[bit] {
+				v |= 1 << bit
+			}
+		}
+	}
+	return v
+}
+
+func (p *IOPort) read(addr uint16) uint8 {
+	p.update()
+	return p.peek(addr)
+}
+
+func (p *IOPort) peek(addr uint16) uint8 {
+	if addr == 0 {
+		return p.direction
+	}
+	return p.Pins()
+}
+
+func (p *IOPort) write(addr uint16, v uint8) {
+	if addr == 0 {
+		// pins that stop being outputs keep their level until it fades
+		now := p.bus.TickCount()
+		for bit := 0; bit < 8; bit++ {
+			m := uint8(1) << bit
+			switch {
+			case v&m != 0:
+				p.fading &^= m
+			case p.direction&m != 0 && p.data&m != 0:
+				p.fading |= m
+				p.fadeAt[bit] = now + p.FadeCycles
+			}
+		}
+		p.direction = v
+	} else {
+		p.data = v
+	}
+	p.update()
+}
+
+// update works out the level of the pins and tells OnChange if they have changed.
+func (p *IOPort) update() {
+	if pins := p.Pins(); pins != p.pins {
+		p.pins = pins
+		if p.OnChange != nil {
+			p.OnChange(pins)
+		}
+	}
+}
+
+type ioPortSnapshot struct {
+	Direction uint8
+	Data      uint8
+	Fading    uint8
+	FadeAt    [8]uint64
+}
+
+func (p *IOPort) snapshot() *ioPortSnapshot {
+	return &ioPortSnapshot{Direction: p.direction, Data: p.data, Fading: p.fading, FadeAt: p.fadeAt}
+}
+
+func (p *IOPort) restore(s *ioPortSnapshot) {
+	p.direction, p.data, p.fading, p.fadeAt = s.Direction, s.Data, s.Fading, s.FadeAt
+	p.update()
+}
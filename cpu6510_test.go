@@ -0,0 +1,130 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"os"
+	"testing"
+)
+
+func newTest6510() (*Bus, *Cpu6502, *Ram) {
+	// LDA #$2f, STA $00, LDA #$37, STA $01, LDA $01, LDA $00
+	return newRamCpu(NewCpu6510, 0xa9, 0x2f, 0x85, 0x00, 0xa9, 0x37, 0x85, 0x01, 0xa5, 0x01, 0xa5, 0x00)
+}
+
+func TestIOPort(t *testing.T) {
+	bus, cpu, ram := newTest6510()
+	var changes []uint8
+	cpu.Port.OnChange = func(pins uint8) { changes = append(changes, pins) }
+	cpu.Port.Driven = 0x10
+	cpu.Port.Input = 0x00
+
+	for i := 0; i < 5; i++ {
+		step(bus, cpu)
+	}
+	if cpu.A != 0x27 {
+		t.Errorf("read $%02x from $01, expected $27", cpu.A)
+	}
+	step(bus, cpu)
+	if cpu.A != 0x2f {
+		t.Errorf("read $%02x from $00, expected $2f", cpu.A)
+	}
+	if ram.bytes[0] != 0x2f || ram.bytes[1] != 0x37 {
+		t.Errorf("RAM under the port holds $%02x $%02x", ram.bytes[0], ram.bytes[1])
+	}
+	if len(changes) != 1 || changes[0] != 0x27 {
+		t.Errorf("saw changes %v", changes)
+	}
+
+	cpu.Port.Input = 0x10
+	if pins := cpu.Port.Pins(); pins != 0x37 {
+		t.Errorf("pins $%02x with an input driven high", pins)
+	}
+}
+
+func TestIOPortFade(t *testing.T) {
+	bus, cpu, _ := newTest6510()
+	cpu.Port.FadeCycles = 1000
+	for i := 0; i < 4; i++ {
+		step(bus, cpu)
+	}
+
+	// bit 7 was never an output so floats low, bit 0 was left high and fades
+	cpu.write(0x0000, 0x2e)
+	if pins := cpu.Port.Pins(); pins != 0x27 {
+		t.Errorf("pins $%02x just after making bit 0 an input", pins)
+	}
+	tickFor(bus, 1000)
+	if pins := cpu.Port.Pins(); pins != 0x26 {
+		t.Errorf("pins $%02x after the bit faded", pins)
+	}
+
+	var changes []uint8
+	cpu.Port.OnChange = func(pins uint8) { changes = append(changes, pins) }
+	cpu.peek(0x0001)
+	if len(changes) != 0 {
+		t.Errorf("peeking the port saw changes %v", changes)
+	}
+	cpu.read(0x0001)
+	if len(changes) != 1 || changes[0] != 0x26 {
+		t.Errorf("reading the port after the fade saw changes %v", changes)
+	}
+}
+
+func TestFunctionalTest6510(t *testing.T) {
+	dat, err := os.ReadFile("functional_tests/6502_functional_test.bin")
+	if err != nil {
+		t.Fatal("Unable to open test binary")
+	}
+	bus, cpu, ram := newTest6510()
+	for a, v := range dat {
+		ram.Write(uint16(a), v)
+	}
+	cpu.PC = 0x0400
+	pc := cpu.PC
+	for pc != 0x3469 {
+		step(bus, cpu)
+		if pc == cpu.PC {
+			t.Fatalf("Test trapped at $%04x : %s", cpu.PC, cpu.StatusString())
+		}
+		pc = cpu.PC
+	}
+}
+
+func TestIOPortSnapshot(t *testing.T) {
+	bus, cpu, _ := newTest6510()
+	for i := 0; i < 4; i++ {
+		step(bus, cpu)
+	}
+	data, err := cpu.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu.Port.FadeCycles = 0
+	cpu.write(0x0000, 0x00)
+	cpu.write(0x0001, 0x00)
+	var changes []uint8
+	cpu.Port.OnChange = func(pins uint8) { changes = append(changes, pins) }
+	if err := cpu.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != 0x27 {
+		t.Errorf("restoring the port saw changes %v", changes)
+	}
+	if cpu.Port.Direction() != 0x2f || cpu.Port.Data() != 0x37 {
+		t.Errorf("restored port $%02x $%02x", cpu.Port.Direction(), cpu.Port.Data())
+	}
+}
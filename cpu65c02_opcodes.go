@@ -0,0 +1,148 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// initOpcodes65C02 replaces the NMOS opcodes set up by initOpcodes with those of the W65C02S. The
+// unofficial NMOS opcodes all become NOPs of the documented length and cycle count. Unlike the
+// NMOS part ASL, LSR, ROL and ROR abs,X only spend a cycle fixing up the address when indexing
+// crosses a page.
+func (c *Cpu6502) initOpcodes65C02() {
+	c.opCodes[0x02] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0x03] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x04] = &opcode{mne: "TSB", wait: 5, addrMode: zp, modify: c.tsb}
+	c.opCodes[0x07] = &opcode{mne: "RMB0", wait: 5, addrMode: zp, modify: c.rmb(0)}
+	c.opCodes[0x0b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x0c] = &opcode{mne: "TSB", wait: 6, addrMode: abs, modify: c.tsb}
+	c.opCodes[0x0f] = &opcode{mne: "BBR0", wait: 5, addrMode: zpr, exec: c.bbr(0)}
+
+	c.opCodes[0x12] = &opcode{mne: "ORA", wait: 5, addrMode: izp, exec: c.ora}
+	c.opCodes[0x13] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x14] = &opcode{mne: "TRB", wait: 5, addrMode: zp, modify: c.trb}
+	c.opCodes[0x17] = &opcode{mne: "RMB1", wait: 5, addrMode: zp, modify: c.rmb(1)}
+	c.opCodes[0x1a] = &opcode{mne: "INC", wait: 2, addrMode: none, exec: c.incAcc}
+	c.opCodes[0x1b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x1c] = &opcode{mne: "TRB", wait: 6, addrMode: abs, modify: c.trb}
+	c.opCodes[0x1e] = &opcode{mne: "ASL", wait: 6, addrMode: abx, modify: c.asl, fixupOnCross: true}
+	c.opCodes[0x1f] = &opcode{mne: "BBR1", wait: 5, addrMode: zpr, exec: c.bbr(1)}
+
+	c.opCodes[0x22] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0x23] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x27] = &opcode{mne: "RMB2", wait: 5, addrMode: zp, modify: c.rmb(2)}
+	c.opCodes[0x2b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x2f] = &opcode{mne: "BBR2", wait: 5, addrMode: zpr, exec: c.bbr(2)}
+
+	c.opCodes[0x32] = &opcode{mne: "AND", wait: 5, addrMode: izp, exec: c.and}
+	c.opCodes[0x33] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x34] = &opcode{mne: "BIT", wait: 4, addrMode: zpx, exec: c.bit}
+	c.opCodes[0x37] = &opcode{mne: "RMB3", wait: 5, addrMode: zp, modify: c.rmb(3)}
+	c.opCodes[0x3a] = &opcode{mne: "DEC", wait: 2, addrMode: none, exec: c.decAcc}
+	c.opCodes[0x3b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x3c] = &opcode{mne: "BIT", wait: 4, addrMode: abx, exec: c.bit}
+	c.opCodes[0x3e] = &opcode{mne: "ROL", wait: 6, addrMode: abx, modify: c.rol, fixupOnCross: true}
+	c.opCodes[0x3f] = &opcode{mne: "BBR3", wait: 5, addrMode: zpr, exec: c.bbr(3)}
+
+	c.opCodes[0x42] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0x43] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x44] = &opcode{mne: "NOP*", wait: 3, addrMode: zp, exec: c.nop}
+	c.opCodes[0x47] = &opcode{mne: "RMB4", wait: 5, addrMode: zp, modify: c.rmb(4)}
+	c.opCodes[0x4b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x4f] = &opcode{mne: "BBR4", wait: 5, addrMode: zpr, exec: c.bbr(4)}
+
+	c.opCodes[0x52] = &opcode{mne: "EOR", wait: 5, addrMode: izp, exec: c.eor}
+	c.opCodes[0x53] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x54] = &opcode{mne: "NOP*", wait: 4, addrMode: zpx, exec: c.nop}
+	c.opCodes[0x57] = &opcode{mne: "RMB5", wait: 5, addrMode: zp, modify: c.rmb(5)}
+	c.opCodes[0x5a] = &opcode{mne: "PHY", wait: 3, addrMode: none, exec: c.phy, cycles: pushCycles}
+	c.opCodes[0x5b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x5c] = &opcode{mne: "NOP*", wait: 8, addrMode: abs, cycles: nop5cCycles}
+	c.opCodes[0x5e] = &opcode{mne: "LSR", wait: 6, addrMode: abx, modify: c.lsr, fixupOnCross: true}
+	c.opCodes[0x5f] = &opcode{mne: "BBR5", wait: 5, addrMode: zpr, exec: c.bbr(5)}
+
+	c.opCodes[0x62] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0x63] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x64] = &opcode{mne: "STZ", wait: 3, addrMode: zp, store: c.stz}
+	c.opCodes[0x67] = &opcode{mne: "RMB6", wait: 5, addrMode: zp, modify: c.rmb(6)}
+	c.opCodes[0x6b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x6c] = &opcode{mne: "JMP", wait: 6, addrMode: indFixed, cycles: jmpIndFixedCycles}
+	c.opCodes[0x6f] = &opcode{mne: "BBR6", wait: 5, addrMode: zpr, exec: c.bbr(6)}
+
+	c.opCodes[0x72] = &opcode{mne: "ADC", wait: 5, addrMode: izp, exec: c.adc}
+	c.opCodes[0x73] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x74] = &opcode{mne: "STZ", wait: 4, addrMode: zpx, store: c.stz}
+	c.opCodes[0x77] = &opcode{mne: "RMB7", wait: 5, addrMode: zp, modify: c.rmb(7)}
+	c.opCodes[0x7a] = &opcode{mne: "PLY", wait: 4, addrMode: none, exec: c.ply, cycles: pullCycles}
+	c.opCodes[0x7b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x7c] = &opcode{mne: "JMP", wait: 6, addrMode: iax, cycles: jmpIaxCycles}
+	c.opCodes[0x7e] = &opcode{mne: "ROR", wait: 6, addrMode: abx, modify: c.ror, fixupOnCross: true}
+	c.opCodes[0x7f] = &opcode{mne: "BBR7", wait: 5, addrMode: zpr, exec: c.bbr(7)}
+
+	c.opCodes[0x80] = &opcode{mne: "BRA", wait: 3, addrMode: rel, exec: c.bra}
+	c.opCodes[0x82] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0x83] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x87] = &opcode{mne: "SMB0", wait: 5, addrMode: zp, modify: c.smb(0)}
+	c.opCodes[0x89] = &opcode{mne: "BIT", wait: 2, addrMode: imm, exec: c.bitImm}
+	c.opCodes[0x8b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x8f] = &opcode{mne: "BBS0", wait: 5, addrMode: zpr, exec: c.bbs(0)}
+
+	c.opCodes[0x92] = &opcode{mne: "STA", wait: 5, addrMode: izp, store: c.sta}
+	c.opCodes[0x93] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x97] = &opcode{mne: "SMB1", wait: 5, addrMode: zp, modify: c.smb(1)}
+	c.opCodes[0x9b] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0x9c] = &opcode{mne: "STZ", wait: 4, addrMode: abs, store: c.stz}
+	c.opCodes[0x9e] = &opcode{mne: "STZ", wait: 5, addrMode: abx, store: c.stz}
+	c.opCodes[0x9f] = &opcode{mne: "BBS1", wait: 5, addrMode: zpr, exec: c.bbs(1)}
+
+	c.opCodes[0xa3] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xa7] = &opcode{mne: "SMB2", wait: 5, addrMode: zp, modify: c.smb(2)}
+	c.opCodes[0xab] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xaf] = &opcode{mne: "BBS2", wait: 5, addrMode: zpr, exec: c.bbs(2)}
+
+	c.opCodes[0xb2] = &opcode{mne: "LDA", wait: 5, addrMode: izp, exec: c.lda}
+	c.opCodes[0xb3] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xb7] = &opcode{mne: "SMB3", wait: 5, addrMode: zp, modify: c.smb(3)}
+	c.opCodes[0xbb] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xbf] = &opcode{mne: "BBS3", wait: 5, addrMode: zpr, exec: c.bbs(3)}
+
+	c.opCodes[0xc2] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0xc3] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xc7] = &opcode{mne: "SMB4", wait: 5, addrMode: zp, modify: c.smb(4)}
+	c.opCodes[0xcb] = &opcode{mne: "WAI", wait: 3, addrMode: none, exec: c.wai, cycles: waiStpCycles}
+	c.opCodes[0xcf] = &opcode{mne: "BBS4", wait: 5, addrMode: zpr, exec: c.bbs(4)}
+
+	c.opCodes[0xd2] = &opcode{mne: "CMP", wait: 5, addrMode: izp, exec: c.cmp}
+	c.opCodes[0xd3] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xd4] = &opcode{mne: "NOP*", wait: 4, addrMode: zpx, exec: c.nop}
+	c.opCodes[0xd7] = &opcode{mne: "SMB5", wait: 5, addrMode: zp, modify: c.smb(5)}
+	c.opCodes[0xda] = &opcode{mne: "PHX", wait: 3, addrMode: none, exec: c.phx, cycles: pushCycles}
+	c.opCodes[0xdb] = &opcode{mne: "STP", wait: 3, addrMode: none, exec: c.stp, cycles: waiStpCycles}
+	c.opCodes[0xdc] = &opcode{mne: "NOP*", wait: 4, addrMode: abs, exec: c.nop}
+	c.opCodes[0xdf] = &opcode{mne: "BBS5", wait: 5, addrMode: zpr, exec: c.bbs(5)}
+
+	c.opCodes[0xe2] = &opcode{mne: "NOP*", wait: 2, addrMode: imm, exec: c.nop}
+	c.opCodes[0xe3] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xe7] = &opcode{mne: "SMB6", wait: 5, addrMode: zp, modify: c.smb(6)}
+	c.opCodes[0xeb] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xef] = &opcode{mne: "BBS6", wait: 5, addrMode: zpr, exec: c.bbs(6)}
+
+	c.opCodes[0xf2] = &opcode{mne: "SBC", wait: 5, addrMode: izp, exec: c.sbc}
+	c.opCodes[0xf3] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xf4] = &opcode{mne: "NOP*", wait: 4, addrMode: zpx, exec: c.nop}
+	c.opCodes[0xf7] = &opcode{mne: "SMB7", wait: 5, addrMode: zp, modify: c.smb(7)}
+	c.opCodes[0xfa] = &opcode{mne: "PLX", wait: 4, addrMode: none, exec: c.plx, cycles: pullCycles}
+	c.opCodes[0xfb] = &opcode{mne: "NOP*", wait: 1, addrMode: none, cycles: noCycles}
+	c.opCodes[0xfc] = &opcode{mne: "NOP*", wait: 4, addrMode: abs, exec: c.nop}
+	c.opCodes[0xff] = &opcode{mne: "BBS7", wait: 5, addrMode: zpr, exec: c.bbs(7)}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// ================================================================================================
+// 65C02 Instructions
+// ================================================================================================
+
+func (cpu *Cpu6502) stz(addr uint16) {
+	cpu.write(addr, 0)
+}
+
+func (cpu *Cpu6502) incAcc(a uint16) {
+	cpu.A += 1
+	cpu.testAndSetNZ(cpu.A)
+}
+
+func (cpu *Cpu6502) decAcc(a uint16) {
+	cpu.A -= 1
+	cpu.testAndSetNZ(cpu.A)
+}
+
+func (cpu *Cpu6502) bitImm(addr uint16) {
+	// BIT #imm only affects the Z flag
+	cpu.testAndSetZero(cpu.read(addr) & cpu.A)
+}
+
+func (cpu *Cpu6502) tsb(v uint8) uint8 {
+	cpu.testAndSetZero(v & cpu.A)
+	return v | cpu.A
+}
+
+func (cpu *Cpu6502) trb(v uint8) uint8 {
+	cpu.testAndSetZero(v & cpu.A)
+	return v &^ cpu.A
+}
+
+func (cpu *Cpu6502) phx(addr uint16) { cpu.stackPush(cpu.X) }
+func (cpu *Cpu6502) phy(addr uint16) { cpu.stackPush(cpu.Y) }
+
+func (cpu *Cpu6502) plx(addr uint16) {
+	cpu.X = cpu.stackPop()
+	cpu.testAndSetNZ(cpu.X)
+}
+
+func (cpu *Cpu6502) ply(addr uint16) {
+	cpu.Y = cpu.stackPop()
+	cpu.testAndSetNZ(cpu.Y)
+}
+
+func (cpu *Cpu6502) bra(addr uint16) {
+	cpu.branch(addr)
+}
+
+func (cpu *Cpu6502) rmb(bit uint) func(uint8) uint8 {
+	return func(v uint8) uint8 { return v &^ (1 << bit) }
+}
+
+func (cpu *Cpu6502) smb(bit uint) func(uint8) uint8 {
+	return func(v uint8) uint8 { return v | (1 << bit) }
+}
+
+// bbr and bbs are given the zero page address to test, the branch offset has already been
+// fetched into cpu.data.
+func (cpu *Cpu6502) bbr(bit uint) func(uint16) {
+	return func(addr uint16) {
+		cpu.branchOnBit(cpu.read(addr)&(1<<bit) == 0)
+	}
+}
+
+func (cpu *Cpu6502) bbs(bit uint) func(uint16) {
+	return func(addr uint16) {
+		cpu.branchOnBit(cpu.read(addr)&(1<<bit) != 0)
+	}
+}
+
+func (cpu *Cpu6502) branchOnBit(taken bool) {
+	if taken {
+		cpu.branch(cpu.PC + uint16(int8(cpu.data)))
+	}
+}
+
+// wai waits for an interrupt. The CPU wakes when an IRQ or NMI is pending and services it,
+// unless it is an IRQ and interrupts are disabled in which case execution simply continues.
+func (cpu *Cpu6502) wai(addr uint16) {
+	cpu.waiting = true
+}
+
+// stp stops the processor until it is reset.
+func (cpu *Cpu6502) stp(addr uint16) {
+	cpu.halted = true
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTest65C02(rom []uint8) (*Bus, *Cpu6502) {
+	bus := NewBus()
+	bus.Addressable(0x0000, 0x05ff, NewRam(0x0600))
+	bus.Addressable(0x0600, uint16(0x0600+len(rom)-1), NewRom(rom))
+	bus.Addressable(0xfffa, 0xffff, NewRom([]uint8{0x00, 0x00, 0x00, 0x06, 0x00, 0x05}))
+
+	return bus, NewCpu65C02(bus)
+}
+
+func run(bus *Bus, cpu *Cpu6502, end uint16) {
+	for cpu.PC != end {
+		bus.Tick()
+	}
+}
+
+func TestFunctionalTest65C02(t *testing.T) {
+	bus := NewBus()
+	bus.Addressable(0x0000, 0xffff, NewRam(0x10000))
+	cpu := NewCpu65C02(bus)
+
+	dat, err := os.ReadFile("functional_tests/6502_functional_test.bin")
+	if err != nil {
+		t.Fatal("Unable to open test binary")
+	}
+	for a, b := range dat {
+		bus.Write(uint16(a), b)
+	}
+
+	cpu.PC = 0x0400
+	pc := cpu.PC
+	for pc != 0x3469 {
+		for cpu.Waiting() {
+			bus.Tick()
+		}
+		bus.Tick()
+		if pc == cpu.PC {
+			t.Fatalf("Test trapped at $%04x : %s", cpu.PC, cpu.StatusString())
+		}
+		pc = cpu.PC
+	}
+}
+
+func TestNoUnofficialOpcodes65C02(t *testing.T) {
+	_, cpu := newTest65C02([]uint8{0xea})
+	for i, op := range cpu.opCodes {
+		if op == nil {
+			t.Errorf("opcode $%02x is not defined", i)
+		} else if strings.HasSuffix(op.mne, "*") && op.mne != "NOP*" {
+			t.Errorf("opcode $%02x is still %s", i, op.mne)
+		}
+	}
+}
+
+func TestReservedNops65C02(t *testing.T) {
+	for _, tc := range []struct {
+		opcode uint8
+		length uint16
+	}{{0x03, 1}, {0xeb, 1}, {0x02, 2}, {0x44, 2}, {0xf4, 2}, {0x5c, 3}, {0xfc, 3}} {
+		bus, cpu := newTest65C02([]uint8{tc.opcode, 0xff, 0xff, 0xff})
+		bus.Tick()
+		if cpu.PC != 0x0600+tc.length {
+			t.Errorf("opcode $%02x is not %d bytes long", tc.opcode, tc.length)
+		}
+	}
+}
+
+func TestNewInstructions65C02(t *testing.T) {
+	rom := []uint8{
+		0xa9, 0x0f, //       LDA #$0f
+		0x85, 0x10, //       STA $10
+		0x64, 0x11, //       STZ $11
+		0xa9, 0x3c, //       LDA #$3c
+		0x04, 0x11, //       TSB $11
+		0x14, 0x10, //       TRB $10
+		0xda,       //       PHX
+		0xa2, 0x05, //       LDX #$05
+		0xfa,       //       PLX
+		0x1a,       //       INC A
+		0xb2, 0x20, //       LDA ($20)
+		0x80, 0x01, //       BRA +1
+		0xdb,       //       STP
+		0xf7, 0x11, //       SMB7 $11
+		0x0f, 0x11, 0x01, // BBR0 $11,+1
+		0xdb, //       STP
+		0xea, //       NOP
+	}
+	bus, cpu := newTest65C02(rom)
+	bus.Write(0x0020, 0x03)
+	bus.Write(0x0003, 0x99)
+	run(bus, cpu, uint16(0x0600+len(rom)))
+
+	if v := bus.Read(0x10); v != 0x03 {
+		t.Errorf("$10 is $%02x not $03", v)
+	}
+	if v := bus.Read(0x11); v != 0xbc {
+		t.Errorf("$11 is $%02x not $bc", v)
+	}
+	if cpu.X != 0 {
+		t.Errorf("X is $%02x not $00", cpu.X)
+	}
+	if cpu.A != 0x99 {
+		t.Errorf("A is $%02x not $99", cpu.A)
+	}
+}
+
+func TestJmpIndirect65C02(t *testing.T) {
+	for _, tc := range []struct {
+		newCpu func(*Bus) *Cpu6502
+		target uint16
+	}{{NewCpu6502, 0x0200}, {NewCpu65C02, 0x0300}} {
+		bus := NewBus()
+		bus.Addressable(0x0000, 0xffff, NewRam(0x10000))
+		bus.Write(0x0400, 0x6c)
+		bus.Write(0x0401, 0xff)
+		bus.Write(0x0402, 0x10)
+		bus.Write(0x10ff, 0x00)
+		bus.Write(0x1000, 0x02)
+		bus.Write(0x1100, 0x03)
+		cpu := tc.newCpu(bus)
+		cpu.PC = 0x0400
+		bus.Tick()
+		if cpu.PC != tc.target {
+			t.Errorf("JMP ($10ff) went to $%04x not $%04x", cpu.PC, tc.target)
+		}
+	}
+}
+
+func TestDecimalFlags65C02(t *testing.T) {
+	rom := []uint8{
+		0xf8,       // SED
+		0x18,       // CLC
+		0xa9, 0x99, // LDA #$99
+		0x69, 0x01, // ADC #$01
+	}
+	bus, cpu := newTest65C02(rom)
+	run(bus, cpu, 0x0604)
+	for cpu.Waiting() {
+		bus.Tick()
+	}
+
+	start := bus.TickCount()
+	run(bus, cpu, uint16(0x0600+len(rom)))
+	for cpu.Waiting() {
+		bus.Tick()
+	}
+
+	if cpu.A != 0x00 || !cpu.FlagSet(P_ZERO) || cpu.FlagSet(P_NEGATIVE) || !cpu.FlagSet(P_CARRY) {
+		t.Errorf("unexpected result %s", cpu.StatusString())
+	}
+	if n := bus.TickCount() - start; n != 3 {
+		t.Errorf("decimal ADC took %d cycles not 3", n)
+	}
+}
+
+func TestInterruptClearsDecimal65C02(t *testing.T) {
+	bus, cpu := newTest65C02([]uint8{0xf8, 0x00})
+	run(bus, cpu, 0x0500)
+	if cpu.FlagSet(P_DECIMAL_MODE) {
+		t.Error("BRK did not clear the decimal flag")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import "fmt"
+
+// StopReason says why Debugger.Run returned.
+type StopReason int
+
+const (
+	StopBreakpoint StopReason = iota
+	StopWatchpoint
+	StopStep
+	StopHalted
+	StopLimit
+	StopError
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopStep:
+		return "step"
+	case StopHalted:
+		return "halted"
+	case StopLimit:
+		return "limit"
+	case StopError:
+		return "error"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+// Breakpoint stops execution before the instruction at Addr, if Cond is nil or returns true.
+type Breakpoint struct {
+	ID       int
+	Addr     uint16
+	Cond     func(Registers) bool
+	Disabled bool
+	Hits     int
+}
+
+// Watchpoint stops execution after the CPU reads or writes an address between Start and End
+// inclusive.
+type Watchpoint struct {
+	ID       int
+	Start    uint16
+	End      uint16
+	Read     bool
+	Write    bool
+	Disabled bool
+	Hits     int
+
+	watch *Watch
+}
+
+// Stop describes why Debugger.Run returned. For watchpoints Addr, Value and Write describe the
+// access and PC is the address of the instruction that made it.
+type Stop struct {
+	Reason     StopReason
+	Breakpoint *Breakpoint
+	Watchpoint *Watchpoint
+	PC         uint16
+	Addr       uint16
+	Value      uint8
+	Write      bool
+	Err        error
+}
+
+func (s Stop) String() string {
+	switch s.Reason {
+	case StopBreakpoint:
+		return fmt.Sprintf("breakpoint %d at $%04x", s.Breakpoint.ID, s.PC)
+	case StopWatchpoint:
+		kind := "read"
+		if s.Write {
+			kind = "write"
+		}
+		return fmt.Sprintf("watchpoint %d %s $%02x at $%04x by $%04x", s.Watchpoint.ID, kind, s.Value, s.Addr, s.PC)
+	case StopError:
+		return fmt.Sprintf("error at $%04x: %v", s.PC, s.Err)
+	}
+	return fmt.Sprintf("%s at $%04x", s.Reason, s.PC)
+}
+
+// Debugger runs a CPU until it hits a breakpoint or watchpoint.
+type Debugger struct {
+	cpu *Cpu6502
+	bus *Bus
+
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+
+	hit *Stop
+}
+
+func NewDebugger(cpu *Cpu6502) *Debugger {
+	return &Debugger{cpu: cpu, bus: cpu.bus, nextID: 1}
+}
+
+// Break adds a breakpoint at addr.
+func (d *Debugger) Break(addr uint16) *Breakpoint {
+	return d.BreakIf(addr, nil)
+}
+
+// BreakIf adds a breakpoint at addr that only stops when cond returns true.
+func (d *Debugger) BreakIf(addr uint16, cond func(Registers) bool) *Breakpoint {
+	bp := &Breakpoint{ID: d.nextID, Addr: addr, Cond: cond}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+// Watch adds a watchpoint on reads, writes or both between start and end inclusive.
+func (d *Debugger) Watch(start uint16, end uint16, read bool, write bool) *Watchpoint {
+	wp := &Watchpoint{ID: d.nextID, Start: start, End: end, Read: read, Write: write}
+	d.nextID++
+	wp.watch = d.bus.Watch(start, end, read, write, func(addr uint16, v uint8, write bool) {
+		if wp.Disabled || d.hit != nil {
+			return
+		}
+		wp.Hits++
+		d.hit = &Stop{
+			Reason:     StopWatchpoint,
+			Watchpoint: wp,
+			PC:         d.cpu.opAddr,
+			Addr:       addr,
+			Value:      v,
+			Write:      write,
+		}
+	})
+	d.watchpoints = append(d.watchpoints, wp)
+	return wp
+}
+
+// Delete removes the breakpoint or watchpoint with the given ID, returning false if there isn't
+// one.
+func (d *Debugger) Delete(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	for i, wp := range d.watchpoints {
+		if wp.ID == id {
+			wp.watch.Remove()
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint { return d.breakpoints }
+
+func (d *Debugger) Watchpoints() []*Watchpoint { return d.watchpoints }
+
+// Run ticks the bus until a breakpoint or watchpoint fires, the CPU halts, Tick returns an error
+// or, if limit is not zero, limit ticks have passed. A breakpoint at the current PC does not fire
+// until another instruction has run, so Run can be called again to carry on.
+func (d *Debugger) Run(limit uint64) Stop {
+	return d.run(limit, false)
+}
+
+// Step runs one instruction, or an interrupt sequence, stopping early if a watchpoint
+// fires. Breakpoints are ignored.
+func (d *Debugger) Step() Stop {
+	return d.run(0, true)
+}
+
+func (d *Debugger) run(limit uint64, step bool) Stop {
+	cpu := d.cpu
+	started := cpu.instructions
+	if cpu.Waiting() {
+		// stop once the instruction in progress finishes
+		started--
+	}
+	d.hit = nil
+	for ticks := uint64(0); limit == 0 || ticks < limit; ticks++ {
+		if cpu.Halted() {
+			return Stop{Reason: StopHalted, PC: cpu.PC}
+		}
+
+		if err := d.bus.Tick(); err != nil {
+			return Stop{Reason: StopError, PC: cpu.opAddr, Err: err}
+		}
+		if d.hit != nil {
+			return *d.hit
+		}
+
+		if cpu.Waiting() || cpu.instructions == started {
+			continue
+		}
+		if step {
+			return Stop{Reason: StopStep, PC: cpu.PC}
+		}
+		if bp := d.breakpointAt(cpu.PC); bp != nil {
+			bp.Hits++
+			return Stop{Reason: StopBreakpoint, Breakpoint: bp, PC: cpu.PC}
+		}
+	}
+	return Stop{Reason: StopLimit, PC: cpu.PC}
+}
+
+func (d *Debugger) breakpointAt(pc uint16) *Breakpoint {
+	for _, bp := range d.breakpoints {
+		if bp.Disabled || bp.Addr != pc {
+			continue
+		}
+		if bp.Cond == nil || bp.Cond(d.cpu.Registers()) {
+			return bp
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import "testing"
+
+// countLoop stores X to $10 counting from 1 to 5 then jams
+var countLoop = []uint8{
+	0xa2, 0x00, // 0600 LDX #$00
+	0xe8,       // 0602 INX
+	0x86, 0x10, // 0603 STX $10
+	0xe0, 0x05, // 0605 CPX #$05
+	0xd0, 0xf9, // 0607 BNE $0602
+	0x02, //       0609 JAM
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		_, cpu := newTestCpu(countLoop)
+		cpu.CycleAccurate = accurate
+		dbg := NewDebugger(cpu)
+		bp := dbg.Break(0x0603)
+		cond := dbg.BreakIf(0x0605, func(r Registers) bool { return r.X == 3 })
+
+		for x := uint8(1); x <= 3; x++ {
+			stop := dbg.Run(1000)
+			if stop.Reason != StopBreakpoint || stop.Breakpoint != bp || cpu.X != x {
+				t.Fatalf("stopped by %v with X=%d, expected breakpoint %d with X=%d", stop, cpu.X, bp.ID, x)
+			}
+		}
+		if stop := dbg.Run(1000); stop.Breakpoint != cond || cpu.PC != 0x0605 {
+			t.Fatalf("stopped by %v, expected conditional breakpoint", stop)
+		}
+
+		dbg.Delete(bp.ID)
+		if stop := dbg.Run(1000); stop.Reason != StopHalted || stop.PC != 0x0609 {
+			t.Errorf("stopped by %v, expected to halt", stop)
+		}
+		if bp.Hits != 3 || cond.Hits != 1 {
+			t.Errorf("hits %d and %d", bp.Hits, cond.Hits)
+		}
+	}
+}
+
+func TestDebuggerWatchpoints(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		bus, cpu := newTestCpu(countLoop)
+		cpu.CycleAccurate = accurate
+		dbg := NewDebugger(cpu)
+		wp := dbg.Watch(0x0010, 0x0010, false, true)
+		dbg.Watch(0x0605, 0x0605, true, false).Disabled = true
+
+		stop := dbg.Run(1000)
+		if stop.Reason != StopWatchpoint || stop.Watchpoint != wp || stop.PC != 0x0603 ||
+			stop.Addr != 0x0010 || stop.Value != 0x01 || !stop.Write {
+			t.Fatalf("stopped by %v", stop)
+		}
+		if stop := dbg.Run(1000); stop.Value != 0x02 {
+			t.Fatalf("stopped by %v, expected the second write", stop)
+		}
+
+		dbg.Delete(wp.ID)
+		if len(bus.watches) != 1 {
+			t.Errorf("%d watches left on the bus", len(bus.watches))
+		}
+		if stop := dbg.Run(1000); stop.Reason != StopHalted {
+			t.Errorf("stopped by %v, expected to halt", stop)
+		}
+	}
+}
+
+func TestDebuggerStep(t *testing.T) {
+	bus, cpu := newTestCpu(countLoop)
+	dbg := NewDebugger(cpu)
+	dbg.Break(0x0602)
+
+	for _, pc := range []uint16{0x0602, 0x0603, 0x0605} {
+		if stop := dbg.Step(); stop.Reason != StopStep || cpu.PC != pc {
+			t.Fatalf("stepped to $%04x by %v, expected $%04x", cpu.PC, stop, pc)
+		}
+	}
+	if bus.TickCount() != 2+2+3 {
+		t.Errorf("%d ticks", bus.TickCount())
+	}
+
+	if stop := dbg.Run(3); stop.Reason != StopLimit {
+		t.Errorf("stopped by %v, expected the limit", stop)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"os"
+	"testing"
+)
+
+// decimalResult is the accumulator and flags left by ADC or SBC in decimal mode
+type decimalResult struct {
+	a          uint8
+	n, v, z, c bool
+}
+
+// The expected results follow Bruce Clark's "Decimal Mode" tutorial on 6502.org, which describes
+// what real parts do for every input including invalid BCD.
+
+func decimalAdcNMOS(a, b uint8, c bool) decimalResult {
+	ci := 0
+	if c {
+		ci = 1
+	}
+
+	// Seq. 1, the accumulator and carry
+	al := int(a&0x0f) + int(b&0x0f) + ci
+	if al >= 0x0a {
+		al = ((al + 0x06) & 0x0f) + 0x10
+	}
+	sum := int(a&0xf0) + int(b&0xf0) + al
+	if sum >= 0xa0 {
+		sum += 0x60
+	}
+
+	// Seq. 2, N and V from the signed sum before the high digit is adjusted
+	signed := int(int8(a&0xf0)) + int(int8(b&0xf0)) + al
+
+	return decimalResult{
+		a: uint8(sum),
+		n: signed&0x80 != 0,
+		v: signed < -128 || signed > 127,
+		z: uint8(int(a)+int(b)+ci) == 0,
+		c: sum >= 0x100,
+	}
+}
+
+func decimalAdc65C02(a, b uint8, c bool) decimalResult {
+	r := decimalAdcNMOS(a, b, c)
+	r.n, r.z = r.a&0x80 != 0, r.a == 0
+	return r
+}
+
+// binarySbc gives the flags the NMOS 6502 sets for SBC in decimal mode, which are those of the
+// binary subtraction.
+func binarySbc(a, b uint8, c bool) decimalResult {
+	borrow := 1
+	if c {
+		borrow = 0
+	}
+	t := int(a) - int(b) - borrow
+	return decimalResult{
+		a: uint8(t),
+		n: t&0x80 != 0,
+		v: (a^b)&(a^uint8(t))&0x80 != 0,
+		z: uint8(t) == 0,
+		c: t >= 0,
+	}
+}
+
+func decimalSbcNMOS(a, b uint8, c bool) decimalResult {
+	r := binarySbc(a, b, c)
+	borrow := 0
+	if !c {
+		borrow = -1
+	}
+
+	// Seq. 3
+	al := int(a&0x0f) - int(b&0x0f) + borrow
+	if al < 0 {
+		al = ((al - 0x06) & 0x0f) - 0x10
+	}
+	t := int(a&0xf0) - int(b&0xf0) + al
+	if t < 0 {
+		t -= 0x60
+	}
+	r.a = uint8(t)
+	return r
+}
+
+func decimalSbc65C02(a, b uint8, c bool) decimalResult {
+	r := binarySbc(a, b, c)
+	borrow := 0
+	if !c {
+		borrow = -1
+	}
+
+	// Seq. 4
+	al := int(a&0x0f) - int(b&0x0f) + borrow
+	t := int(a) - int(b) + borrow
+	if t < 0 {
+		t -= 0x60
+	}
+	if al < 0 {
+		t -= 0x06
+	}
+	r.a = uint8(t)
+	r.n, r.z = r.a&0x80 != 0, r.a == 0
+	return r
+}
+
+// TestDecimalMode runs ADC and SBC in decimal mode for every pair of operands, valid BCD or not,
+// with and without carry, and checks the accumulator and flags.
+func TestDecimalMode(t *testing.T) {
+	for _, tc := range []struct {
+		variant Variant
+		opcode  uint8
+		name    string
+		expect  func(a, b uint8, c bool) decimalResult
+	}{
+		{NMOS6502, 0x69, "ADC", decimalAdcNMOS},
+		{NMOS6502, 0xe9, "SBC", decimalSbcNMOS},
+		{WDC65C02, 0x69, "ADC", decimalAdc65C02},
+		{WDC65C02, 0xe9, "SBC", decimalSbc65C02},
+	} {
+		bus := NewBus()
+		ram := NewRam(0x10000)
+		bus.Addressable(0x0000, 0xffff, ram)
+		cpu := newCpu(bus, tc.variant)
+		ram.Write(0x0400, tc.opcode)
+
+		failed := 0
+		for a := 0; a < 0x100; a++ {
+			for b := 0; b < 0x100; b++ {
+				for _, c := range []bool{false, true} {
+					ram.Write(0x0401, uint8(b))
+					cpu.PC = 0x0400
+					cpu.A = uint8(a)
+					cpu.P = 0x24 | uint8(P_DECIMAL_MODE)
+					cpu.SetFlagValue(P_CARRY, c)
+					step(bus, cpu)
+
+					got := decimalResult{
+						a: cpu.A,
+						n: cpu.FlagSet(P_NEGATIVE),
+						v: cpu.FlagSet(P_OVERFLOW),
+						z: cpu.FlagSet(P_ZERO),
+						c: cpu.FlagSet(P_CARRY),
+					}
+					if expected := tc.expect(uint8(a), uint8(b), c); got != expected {
+						if failed < 10 {
+							t.Errorf("variant %d $%02x %s #$%02x with C=%v gave %+v, expected %+v",
+								tc.variant, a, tc.name, b, c, got, expected)
+						}
+						failed++
+					}
+				}
+			}
+		}
+		if failed > 0 {
+			t.Errorf("variant %d %s wrong for %d inputs", tc.variant, tc.name, failed)
+		}
+	}
+}
+
+// TestDecimalTestRom runs the decimal test in functional_tests, built from 6502_decimal_test.ca65
+// for each variant. It loads at $0200, ends on the $db opcode and leaves ERROR at $000b zero if
+// every result and flag was right.
+func TestDecimalTestRom(t *testing.T) {
+	for _, tc := range []struct {
+		file    string
+		variant Variant
+	}{
+		{"6502_decimal_test.bin", NMOS6502},
+		{"65c02_decimal_test.bin", WDC65C02},
+	} {
+		dat, err := os.ReadFile("functional_tests/" + tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bus := NewBus()
+		ram := NewRam(0x10000)
+		bus.Addressable(0x0000, 0xffff, ram)
+		cpu := newCpu(bus, tc.variant)
+		for a, v := range dat {
+			ram.Write(uint16(0x0200+a), v)
+		}
+		cpu.PC = 0x0200
+
+		for i := 0; bus.Peek(cpu.PC) != 0xdb; i++ {
+			if i == 100000000 {
+				t.Fatalf("%s never finished, at $%04x", tc.file, cpu.PC)
+			}
+			step(bus, cpu)
+		}
+		if v := ram.Read(0x000b); v != 0 {
+			t.Errorf("%s failed with N1 $%02x, N2 $%02x and carry %d", tc.file, ram.Read(0x0000),
+				ram.Read(0x0001), cpu.Y)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+// Package disasm turns a binary back into source. It follows the flow of the code from its entry
+// points to tell code from data, and writes ca65 source with generated labels that assembles to
+// the same bytes.
+package disasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/noddy76/munch"
+)
+
+// Options control how a binary is disassembled.
+type Options struct {
+	Variant munch.Variant
+	// Entries are where code starts. When the binary covers $fffa-$ffff the vectors there are
+	// entries too, and when there are none at all the code starts at the origin.
+	Entries []uint16
+	// Unofficial follows code through the NMOS unofficial opcodes. Otherwise they are taken to be
+	// data, which they usually are.
+	Unofficial bool
+}
+
+type kind uint8
+
+const (
+	data kind = iota
+	code
+	operand
+	vector
+)
+
+const vectors = 0xfffa
+
+// stops are the instructions that execution doesn't carry on after.
+var stops = map[string]bool{
+	"BRK": true, "RTI": true, "RTS": true, "JMP": true, "BRA": true, "STP": true, "JAM*": true,
+}
+
+type disassembler struct {
+	image  []uint8
+	origin uint16
+	opts   Options
+	ops    map[uint8]munch.Instruction
+	kinds  []kind
+	refs   map[uint16]bool
+	labels *munch.Symbols
+	out    strings.Builder
+}
+
+// Disassemble returns ca65 source for image loaded at origin.
+func Disassemble(image []uint8, origin uint16, opts Options) (string, error) {
+	if len(image) == 0 {
+		return "", errors.New("nothing to disassemble")
+	}
+	if int(origin)+len(image) > 0x10000 {
+		return "", fmt.Errorf("%d bytes don't fit at $%04x", len(image), origin)
+	}
+	d := &disassembler{
+		image:  image,
+		origin: origin,
+		opts:   opts,
+		ops:    map[uint8]munch.Instruction{},
+		kinds:  make([]kind, len(image)),
+		refs:   map[uint16]bool{},
+		labels: munch.NewSymbols(),
+	}
+	for _, in := range munch.Instructions(opts.Variant) {
+		d.ops[in.Opcode] = in
+	}
+
+	entries := opts.Entries
+	for _, entry := range entries {
+		if !d.contains(entry) {
+			return "", fmt.Errorf("entry point $%04x is outside $%04x-$%04x", entry, origin,
+				int(origin)+len(image)-1)
+		}
+	}
+	if d.contains(vectors) && int(origin)+len(image) == 0x10000 {
+		for addr := vectors; addr < 0x10000; addr += 2 {
+			d.kinds[addr-int(origin)] = vector
+			d.kinds[addr+1-int(origin)] = vector
+			target := d.word(uint16(addr))
+			d.refs[target] = true
+			entries = append(entries, target)
+		}
+	}
+	if len(entries) == 0 {
+		entries = []uint16{origin}
+	}
+	for _, entry := range entries {
+		d.refs[entry] = true
+		d.trace(entry)
+	}
+
+	for addr := range d.refs {
+		if d.labelled(addr) {
+			d.labels.Add(fmt.Sprintf("L%04X", addr), addr)
+		}
+	}
+	d.write()
+	return d.out.String(), nil
+}
+
+func (d *disassembler) contains(addr uint16) bool {
+	return addr >= d.origin && int(addr) < int(d.origin)+len(d.image)
+}
+
+func (d *disassembler) word(addr uint16) uint16 {
+	off := int(addr) - int(d.origin)
+	return uint16(d.image[off]) | uint16(d.image[off+1])<<8
+}
+
+// labelled reports whether a reference to addr gets a label, which it does if it's in the image
+// at the start of a line. Zero page is left alone so operands keep their size when reassembled.
+func (d *disassembler) labelled(addr uint16) bool {
+	if addr < 0x100 || !d.contains(addr) {
+		return false
+	}
+	switch d.kinds[addr-d.origin] {
+	case operand:
+		return false
+	case vector:
+		return addr%2 == 0
+	}
+	return true
+}
+
+// decode returns the instruction at addr if it can be code that hasn't been seen yet.
+func (d *disassembler) decode(addr uint16) (munch.Instruction, bool) {
+	if !d.contains(addr) {
+		return munch.Instruction{}, false
+	}
+	off := int(addr - d.origin)
+	in, ok := d.ops[d.image[off]]
+	if !ok || off+in.Size > len(d.image) || (unofficial(in) && !d.opts.Unofficial) {
+		return munch.Instruction{}, false
+	}
+	for i := 0; i < in.Size; i++ {
+		if d.kinds[off+i] != data {
+			return munch.Instruction{}, false
+		}
+	}
+	return in, true
+}
+
+func unofficial(in munch.Instruction) bool {
+	return strings.HasSuffix(in.Mnemonic, "*")
+}
+
+// arg returns the operand bytes of the instruction at addr, the first in the low byte.
+func (d *disassembler) arg(addr uint16, in munch.Instruction) uint16 {
+	off := int(addr - d.origin)
+	switch in.Size {
+	case 2:
+		return uint16(d.image[off+1])
+	case 3:
+		return uint16(d.image[off+1]) | uint16(d.image[off+2])<<8
+	}
+	return 0
+}
+
+// trace marks the code reachable from entry, following branches, jumps and subroutine calls.
+func (d *disassembler) trace(entry uint16) {
+	queue := []uint16{entry}
+	for len(queue) > 0 {
+		addr := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for {
+			in, ok := d.decode(addr)
+			if !ok {
+				break
+			}
+			off := int(addr - d.origin)
+			d.kinds[off] = code
+			for i := 1; i < in.Size; i++ {
+				d.kinds[off+i] = operand
+			}
+
+			next := addr + uint16(in.Size)
+			arg := d.arg(addr, in)
+			switch in.Mode {
+			case "rel":
+				target := next + uint16(int8(arg))
+				d.refs[target] = true
+				queue = append(queue, target)
+			case "zpr":
+				target := next + uint16(int8(arg>>8))
+				d.refs[target] = true
+				queue = append(queue, target)
+			case "abs", "abx", "aby", "ind", "iax":
+				d.refs[arg] = true
+				if in.Mnemonic == "JSR" || (in.Mnemonic == "JMP" && in.Mode == "abs") {
+					queue = append(queue, arg)
+				}
+			}
+			if stops[in.Mnemonic] {
+				break
+			}
+			addr = next
+		}
+	}
+}
+
+// write formats the whole image as source.
+func (d *disassembler) write() {
+	cpu := "6502"
+	if d.opts.Variant == munch.WDC65C02 {
+		cpu = "65C02"
+	}
+	fmt.Fprintf(&d.out, "        .setcpu \"%s\"\n        .org $%04x\n\n", cpu, d.origin)
+
+	for off := 0; off < len(d.image); {
+		addr := d.origin + uint16(off)
+		switch d.kinds[off] {
+		case code:
+			in := d.ops[d.image[off]]
+			d.line(addr, d.instruction(addr, in))
+			if stops[in.Mnemonic] {
+				d.out.WriteString("\n")
+			}
+			off += in.Size
+		case vector:
+			d.line(addr, ".word "+d.labels.FormatOperand("abs", addr, d.word(addr)))
+			off += 2
+		default:
+			n := 1
+			for n < 8 && off+n < len(d.image) && d.kinds[off+n] == data {
+				if _, ok := d.labels.Name(addr + uint16(n)); ok {
+					break
+				}
+				n++
+			}
+			d.line(addr, byteList(d.image[off:off+n]))
+			off += n
+		}
+	}
+}
+
+// instruction formats the instruction at addr. Unofficial opcodes, and WAI and STP which older
+// versions of ca65 don't know, are written as bytes. ca65 uses zero page addressing wherever it
+// can, so absolute addresses in zero page are forced with a:.
+func (d *disassembler) instruction(addr uint16, in munch.Instruction) string {
+	off := int(addr - d.origin)
+	arg := d.arg(addr, in)
+	text := strings.TrimSpace(in.Mnemonic + " " + d.labels.FormatOperand(in.Mode, addr, arg))
+	if unofficial(in) || in.Mnemonic == "WAI" || in.Mnemonic == "STP" {
+		return fmt.Sprintf("%-24s; %s", byteList(d.image[off:off+in.Size]), text)
+	}
+	switch in.Mode {
+	case "abs", "abx", "aby":
+		if arg < 0x100 {
+			return in.Mnemonic + " a:" + d.labels.FormatOperand(in.Mode, addr, arg)
+		}
+	}
+	return text
+}
+
+func (d *disassembler) line(addr uint16, text string) {
+	label := ""
+	if name, ok := d.labels.Name(addr); ok {
+		label = name + ":"
+	}
+	fmt.Fprintf(&d.out, "%-8s%s\n", label, text)
+}
+
+func byteList(bytes []uint8) string {
+	s := make([]string, len(bytes))
+	for i, b := range bytes {
+		s[i] = fmt.Sprintf("$%02x", b)
+	}
+	return ".byte " + strings.Join(s, ", ")
+}
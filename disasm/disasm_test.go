@@ -0,0 +1,142 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package disasm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/noddy76/munch"
+	"github.com/noddy76/munch/asm"
+)
+
+const rom = `
+        .org $ff00
+reset:  LDX #0
+@loop:  LDA table,X
+        STA a:$10,X
+        JSR sub
+        INX
+        CPX #4
+        BNE @loop
+        JMP (ptr)
+table:  .byte 1, 2, 3, 4
+ptr:    .word reset
+sub:    LDA $1234
+        RTS
+        .byte $07, $12
+nmi:    RTI
+        .org $fffa
+        .word nmi, reset, nmi
+`
+
+// roundTrip disassembles the program in src and checks the source assembles back to the same
+// bytes, returning the source.
+func roundTrip(t *testing.T, src string, opts Options) string {
+	t.Helper()
+	prog, err := asm.Assemble(src, opts.Variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, origin := prog.Bytes()
+	out, err := Disassemble(image, origin, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := asm.Assemble(out, opts.Variant)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, out)
+	}
+	if bytes, at := again.Bytes(); at != origin || !reflect.DeepEqual(bytes, image) {
+		t.Fatalf("reassembled to % x at $%04x, expected % x at $%04x from\n%s", bytes, at, image, origin, out)
+	}
+	return out
+}
+
+func TestDisassemble(t *testing.T) {
+	out := roundTrip(t, rom, Options{})
+	for _, line := range []string{
+		`        .setcpu "6502"`,
+		`LFF00:  LDX #$00`,
+		`LFF02:  LDA LFF13,X`,
+		`        STA a:$0010,X`,
+		`        JSR LFF19`,
+		`        BNE LFF02`,
+		`        JMP (LFF17)`,
+		`LFF13:  .byte $01, $02, $03, $04`,
+		`LFF17:  .byte $00, $ff`,
+		`LFF19:  LDA $1234`,
+		`        .byte $07, $12`,
+		`LFF1F:  RTI`,
+		`        .word LFF1F`,
+		`        .word LFF00`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestDisassembleUnofficial(t *testing.T) {
+	src := `
+        .org $0400
+        SLO* $12
+        RTS
+`
+	out := roundTrip(t, src, Options{})
+	if !strings.Contains(out, "L0400:  .byte $07, $12, $60\n") {
+		t.Errorf("unofficial opcode disassembled as code\n%s", out)
+	}
+	out = roundTrip(t, src, Options{Unofficial: true})
+	if !strings.Contains(out, "L0400:  .byte $07, $12          ; SLO* $12\n") ||
+		!strings.Contains(out, "        RTS\n") {
+		t.Errorf("unofficial opcode not followed\n%s", out)
+	}
+}
+
+func TestDisassemble65C02(t *testing.T) {
+	out := roundTrip(t, `
+        .org $0400
+start:  BBR0 $12,skip
+        STZ $0200
+skip:   BRA done
+        .byte $ff
+done:   WAI
+        JMP start
+`, Options{Variant: munch.WDC65C02, Entries: []uint16{0x0400}})
+	for _, line := range []string{
+		`        .setcpu "65C02"`,
+		`L0400:  BBR0 $12,L0406`,
+		`L0406:  BRA L0409`,
+		`        .byte $ff`,
+		`L0409:  .byte $cb               ; WAI`,
+		`        JMP L0400`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestDisassembleErrors(t *testing.T) {
+	if _, err := Disassemble(make([]uint8, 0x200), 0xff00, Options{}); err == nil {
+		t.Error("expected an error for an image past $ffff")
+	}
+	if _, err := Disassemble(make([]uint8, 0x10), 0x0400, Options{Entries: []uint16{0x0500}}); err == nil {
+		t.Error("expected an error for an entry outside the image")
+	}
+}
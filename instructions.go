@@ -0,0 +1,63 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// Instruction describes an opcode for assemblers and other tools working with machine code.
+type Instruction struct {
+	Opcode   uint8
+	Mnemonic string // unofficial opcodes end in *
+	Mode     string // addressing mode, as in TraceRecord
+	Size     int    // bytes including the opcode
+	Cycles   int    // documented cycle count, without page crossing or branch penalties
+}
+
+// Instructions returns the instruction set of variant in opcode order, leaving out opcodes it
+// doesn't define.
+func Instructions(variant Variant) []Instruction {
+	cpu := newCpu(NewBus(), variant)
+	var instructions []Instruction
+	for i, op := range cpu.opCodes {
+		if op == nil {
+			continue
+		}
+		instructions = append(instructions, Instruction{
+			Opcode:   uint8(i),
+			Mnemonic: op.mne,
+			Mode:     op.addrMode.name,
+			Size:     op.addrMode.args + 1,
+			Cycles:   op.wait,
+		})
+	}
+	return instructions
+}
+
+var addrModes = map[string]addrMode{}
+
+func init() {
+	for _, mode := range []addrMode{none, imm, zp, zpx, zpy, izx, izy, abs, abx, aby, ind, izp, iax, zpr, rel} {
+		addrModes[mode.name] = mode
+	}
+}
+
+// FormatOperand formats the operand of an instruction at pc in the given addressing mode exactly
+// as Disassemble does. arg holds the operand bytes, the first in the low byte.
+func FormatOperand(mode string, pc uint16, arg uint16) string {
+	m, ok := addrModes[mode]
+	if !ok {
+		return ""
+	}
+	return m.fmt(pc+1, arg)
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+// InterruptLine is a wired-OR line like the 6502's IRQ and NMI inputs, also used for RDY and SO.
+// Any number of devices can assert it independently and it stays asserted until every one of them
+// has released it.
+type InterruptLine struct {
+	sources map[interface{}]bool
+	count   int // len(sources), which is checked every cycle
+}
+
+func NewInterruptLine() *InterruptLine {
+	return &InterruptLine{sources: map[interface{}]bool{}}
+}
+
+// Assert pulls the line on behalf of source, which can be any comparable value identifying the
+// device, usually the device itself.
+func (l *InterruptLine) Assert(source interface{}) {
+	if !l.sources[source] {
+		l.sources[source] = true
+		l.count++
+	}
+}
+
+// Release lets go of the line on behalf of source.
+func (l *InterruptLine) Release(source interface{}) {
+	if l.sources[source] {
+		delete(l.sources, source)
+		l.count--
+	}
+}
+
+// Set asserts or releases the line on behalf of source.
+func (l *InterruptLine) Set(source interface{}, asserted bool) {
+	if asserted {
+		l.Assert(source)
+	} else {
+		l.Release(source)
+	}
+}
+
+// Asserted reports whether any source is asserting the line.
+func (l *InterruptLine) Asserted() bool {
+	return l.count > 0
+}
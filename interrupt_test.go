@@ -0,0 +1,316 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// interruptRom counts IRQs in $10 and NMIs in $11
+var interruptRom = []uint8{
+	0x78,             // 0600 SEI
+	0xea,             // 0601 NOP
+	0xea,             // 0602 NOP
+	0x58,             // 0603 CLI
+	0xea,             // 0604 NOP
+	0x4c, 0x05, 0x06, // 0605 JMP $0605
+}
+
+func newInterruptTestCpu() (*Bus, *Cpu6502) {
+	bus, cpu := newTestCpu(interruptRom)
+	for a, v := range []uint8{0xe6, 0x10, 0x40} { // 0500 INC $10, RTI
+		bus.Write(0x0500+uint16(a), v)
+	}
+	for a, v := range []uint8{0xe6, 0x11, 0x40} { // 0000 INC $11, RTI
+		bus.Write(uint16(a), v)
+	}
+	return bus, cpu
+}
+
+func tickFor(bus *Bus, ticks int) {
+	for i := 0; i < ticks; i++ {
+		bus.Tick()
+	}
+}
+
+func TestIrqIsLevelTriggered(t *testing.T) {
+	bus, cpu := newInterruptTestCpu()
+	timer, uart := &struct{ int }{1}, &struct{ int }{2}
+	cpu.IRQ.Assert(timer)
+	cpu.IRQ.Assert(uart)
+
+	run(bus, cpu, 0x0603)
+	if v := bus.Read(0x0010); v != 0 {
+		t.Fatalf("IRQ serviced %d times with I set", v)
+	}
+
+	tickFor(bus, 100)
+	n := bus.Read(0x0010)
+	if n < 2 {
+		t.Fatalf("IRQ serviced %d times while asserted", n)
+	}
+
+	cpu.IRQ.Release(timer)
+	tickFor(bus, 100)
+	if bus.Read(0x0010) <= n {
+		t.Fatalf("IRQ stopped while still asserted by another device")
+	}
+
+	cpu.IRQ.Release(uart)
+	tickFor(bus, 20)
+	n = bus.Read(0x0010)
+	tickFor(bus, 100)
+	if v := bus.Read(0x0010); v != n {
+		t.Errorf("IRQ serviced %d more times after being released", v-n)
+	}
+}
+
+func TestIrqRequestWaitsForCli(t *testing.T) {
+	bus, cpu := newInterruptTestCpu()
+	run(bus, cpu, 0x0602)
+	cpu.Irq()
+	tickFor(bus, 100)
+	if v := bus.Read(0x0010); v != 1 {
+		t.Errorf("IRQ serviced %d times, expected once after CLI", v)
+	}
+}
+
+func TestNmiIsEdgeTriggered(t *testing.T) {
+	bus, cpu := newInterruptTestCpu()
+	cpu.NMI.Assert(bus)
+	tickFor(bus, 100)
+	if v := bus.Read(0x0011); v != 1 {
+		t.Fatalf("NMI serviced %d times while held", v)
+	}
+
+	cpu.NMI.Release(bus)
+	tickFor(bus, 10)
+	cpu.NMI.Assert(bus)
+	tickFor(bus, 100)
+	if v := bus.Read(0x0011); v != 2 {
+		t.Errorf("NMI serviced %d times after a second edge", v)
+	}
+}
+
+func TestWaiWithIrqDisabled65C02(t *testing.T) {
+	bus, cpu := newTest65C02([]uint8{
+		0x78,             // 0600 SEI
+		0xcb,             // 0601 WAI
+		0xe8,             // 0602 INX
+		0x4c, 0x03, 0x06, // 0603 JMP $0603
+	})
+	for a, v := range []uint8{0xe6, 0x10, 0x40} {
+		bus.Write(0x0500+uint16(a), v)
+	}
+
+	tickFor(bus, 50)
+	if cpu.X != 0 {
+		t.Fatal("WAI did not wait")
+	}
+	cpu.IRQ.Assert(bus)
+	tickFor(bus, 50)
+	if cpu.X != 1 || bus.Read(0x0010) != 0 {
+		t.Errorf("X=%d and IRQ serviced %d times, expected WAI to carry on without servicing it",
+			cpu.X, bus.Read(0x0010))
+	}
+}
+
+// newSequencingCpu sets up handlers that record X+1 in $10 for an IRQ or BRK and X in $11 for an
+// NMI, then loop.
+func newSequencingCpu(variant Variant, program []uint8) (*Bus, *Cpu6502) {
+	var bus *Bus
+	var cpu *Cpu6502
+	if variant == WDC65C02 {
+		bus, cpu = newTest65C02(program)
+	} else {
+		bus, cpu = newTestCpu(program)
+	}
+	for a, v := range []uint8{0xe8, 0x86, 0x10, 0x4c, 0x03, 0x05} { // INX, STX $10, JMP *
+		bus.Write(0x0500+uint16(a), v)
+	}
+	for a, v := range []uint8{0x86, 0x11, 0x4c, 0x02, 0x00} { // STX $11, JMP *
+		bus.Write(uint16(a), v)
+	}
+	bus.Write(0x0010, 0xff)
+	bus.Write(0x0011, 0xff)
+	cpu.CycleAccurate = true
+	return bus, cpu
+}
+
+func runToHandler(bus *Bus, cpu *Cpu6502) {
+	for i := 0; i < 200 && (cpu.Waiting() || cpu.PC != 0x0503 && cpu.PC != 0x0002); i++ {
+		bus.Tick()
+	}
+}
+
+func TestIrqAfterFlagChange(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		program []uint8
+		x       uint8 // X when the IRQ is serviced
+	}{
+		{"CLI", []uint8{0x58, 0xe8, 0xe8, 0xe8}, 1},
+		{"SEI", []uint8{0x58, 0x78, 0xe8, 0xe8}, 0},
+		{"PLP", []uint8{0xa9, 0x00, 0x48, 0x28, 0xe8, 0xe8}, 1},
+	} {
+		for _, accurate := range []bool{false, true} {
+			bus, cpu := newSequencingCpu(NMOS6502, tc.program)
+			cpu.CycleAccurate = accurate
+			cpu.IRQ.Assert(bus)
+			runToHandler(bus, cpu)
+			if v := bus.Read(0x0010); v != tc.x+1 {
+				t.Errorf("%s: IRQ serviced with X=%d, expected X=%d", tc.name, v-1, tc.x)
+			}
+		}
+	}
+}
+
+func TestIrqDuringTakenBranch(t *testing.T) {
+	program := []uint8{
+		0x58,       // 0600 CLI
+		0xea,       // 0601 NOP
+		0xd0, 0x00, // 0602 BNE $0604
+		0xe8, //       0604 INX
+		0xe8, //       0605 INX
+	}
+	// the branch is on ticks 5 to 7
+	for _, tc := range []struct {
+		ticks int
+		x     uint8
+	}{{4, 0}, {5, 1}} {
+		bus, cpu := newSequencingCpu(NMOS6502, program)
+		tickFor(bus, tc.ticks)
+		cpu.IRQ.Assert(bus)
+		runToHandler(bus, cpu)
+		if v := bus.Read(0x0010); v != tc.x+1 {
+			t.Errorf("IRQ asserted after %d ticks serviced with X=%d, expected X=%d", tc.ticks, v-1, tc.x)
+		}
+	}
+}
+
+func TestNmiHijack(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		variant Variant
+		irq     bool // an IRQ rather than BRK
+		ticks   int  // before NMI is asserted
+		x       uint8
+	}{
+		{"BRK", NMOS6502, false, 3, 0},
+		{"late BRK", NMOS6502, false, 5, 1},
+		{"65C02 BRK", WDC65C02, false, 3, 1},
+		{"IRQ", NMOS6502, true, 3, 0},
+		{"65C02 IRQ", WDC65C02, true, 3, 0},
+	} {
+		program := []uint8{0x00, 0x00}
+		if tc.irq {
+			program = []uint8{0x58, 0xea, 0xea}
+		}
+		bus, cpu := newSequencingCpu(tc.variant, program)
+		if tc.irq {
+			// the IRQ sequence starts on tick 5
+			tickFor(bus, 3)
+			cpu.IRQ.Assert(bus)
+			tickFor(bus, 1)
+		}
+		tickFor(bus, tc.ticks)
+		cpu.NMI.Assert(bus)
+		runToHandler(bus, cpu)
+		if cpu.PC != 0x0002 || bus.Read(0x0011) != tc.x {
+			t.Errorf("%s: NMI serviced with X=%d at $%04x, expected X=%d", tc.name, bus.Read(0x0011), cpu.PC, tc.x)
+		}
+		pushed := bus.Read(0x01fb)
+		if tc.x == 0 && (pushed&uint8(P_BRK_COMMAND) != 0) == tc.irq {
+			t.Errorf("%s: pushed P=%08b", tc.name, pushed)
+		}
+	}
+}
+
+// feedbackPort is the I/O port Klaus Dormann's interrupt test writes to trigger interrupts. Set
+// bits assert the interrupts, as in the test's default I_drive = 1 configuration.
+type feedbackPort struct {
+	cpu    *Cpu6502
+	v      uint8
+	irqBit uint8
+	nmiBit uint8
+}
+
+func (p *feedbackPort) Read(addr uint16) uint8 { return p.v }
+
+func (p *feedbackPort) Write(addr uint16, v uint8) {
+	p.v = v
+	p.cpu.IRQ.Set(p, v&p.irqBit != 0)
+	p.cpu.NMI.Set(p, v&p.nmiBit != 0)
+}
+
+// listingValue finds a value in a ca65 listing, or returns def if it isn't there.
+func listingValue(lst string, re string, def uint64) uint64 {
+	m := regexp.MustCompile(re).FindStringSubmatch(lst)
+	if m == nil {
+		return def
+	}
+	v, err := strconv.ParseUint(m[1], 16, 16)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func TestInterruptTest(t *testing.T) {
+	dat, err := os.ReadFile("functional_tests/6502_interrupt_test.bin")
+	if err != nil {
+		t.Skip("functional_tests/6502_interrupt_test.bin not found")
+	}
+	lstBytes, err := os.ReadFile("functional_tests/6502_interrupt_test.lst")
+	if err != nil {
+		t.Skip("functional_tests/6502_interrupt_test.lst not found")
+	}
+	lst := string(lstBytes)
+	success := uint16(listingValue(lst, `(?m)^([0-9A-F]{6})\s+\d+\s+4C [0-9A-F]{2} [0-9A-F]{2}\s+success\b`, 0))
+	if success == 0 {
+		t.Fatal("success trap not found in the listing")
+	}
+	port := uint16(listingValue(lst, `I_port\s*=\s*\$([0-9a-fA-F]+)`, 0xbffc))
+	irqBit := uint8(1) << listingValue(lst, `IRQ_bit\s*=\s*([0-7])`, 0)
+	nmiBit := uint8(1) << listingValue(lst, `NMI_bit\s*=\s*([0-7])`, 1)
+
+	for _, accurate := range []bool{false, true} {
+		bus := NewBus()
+		cpu := NewCpu6502(bus)
+		bus.Addressable(port, port, &feedbackPort{cpu: cpu, irqBit: irqBit, nmiBit: nmiBit})
+		bus.Addressable(0x0000, 0xffff, NewRam(0x10000))
+		for a, v := range dat {
+			bus.Write(uint16(a), v)
+		}
+		cpu.PC = 0x0400
+		cpu.CycleAccurate = accurate
+
+		pc := cpu.PC
+		for pc != success {
+			bus.Tick()
+			for cpu.Waiting() {
+				bus.Tick()
+			}
+			if pc == cpu.PC {
+				t.Fatalf("Test trapped at $%04x : %s", cpu.PC, cpu.StatusString())
+			}
+			pc = cpu.PC
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+// Package loader reads programs into memory from the file formats they are usually shipped in:
+// Intel HEX, Motorola S-records, Commodore PRG files and raw binaries.
+//
+// Each loader writes into any Addressable, usually a Bus, using Poke when the device has it so
+// that ROMs can be loaded too.
+package loader
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/noddy76/munch"
+)
+
+// Info describes what was loaded.
+type Info struct {
+	Start    uint16 // lowest address written
+	End      uint16 // highest address written
+	Entry    uint16 // entry point, if HasEntry
+	HasEntry bool   // whether the file gives an entry point
+}
+
+type writer struct {
+	mem  munch.Addressable
+	info Info
+	any  bool
+}
+
+func (w *writer) store(addr uint32, bytes []uint8) error {
+	if uint64(addr)+uint64(len(bytes)) > 0x10000 {
+		return fmt.Errorf("%d bytes at $%04x go past $ffff", len(bytes), addr)
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+	poker, poke := w.mem.(munch.Poker)
+	for i, v := range bytes {
+		if poke {
+			poker.Poke(uint16(addr)+uint16(i), v)
+		} else {
+			w.mem.Write(uint16(addr)+uint16(i), v)
+		}
+	}
+	start, end := uint16(addr), uint16(addr)+uint16(len(bytes)-1)
+	if !w.any || start < w.info.Start {
+		w.info.Start = start
+	}
+	if !w.any || end > w.info.End {
+		w.info.End = end
+	}
+	w.any = true
+	return nil
+}
+
+func (w *writer) entry(addr uint32) error {
+	if addr > 0xffff {
+		return fmt.Errorf("entry point $%x is past $ffff", addr)
+	}
+	w.info.Entry, w.info.HasEntry = uint16(addr), true
+	return nil
+}
+
+// File loads the named file into mem, choosing the format from its extension: .hex and .ihx are
+// Intel HEX, .s19, .s28, .s37, .srec and .mot are S-records, .prg is a PRG file and anything else
+// is a raw binary loaded at addr.
+func File(name string, mem munch.Addressable, addr uint16) (Info, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	var info Info
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".hex", ".ihx":
+		info, err = IntelHex(f, mem)
+	case ".s19", ".s28", ".s37", ".srec", ".mot":
+		info, err = SRecord(f, mem)
+	case ".prg":
+		info, err = Prg(f, mem)
+	default:
+		info, err = Raw(f, mem, addr)
+	}
+	if err != nil {
+		return info, fmt.Errorf("%s: %w", name, err)
+	}
+	return info, nil
+}
+
+// Raw loads a binary at addr.
+func Raw(r io.Reader, mem munch.Addressable, addr uint16) (Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Info{}, err
+	}
+	w := &writer{mem: mem}
+	err = w.store(uint32(addr), data)
+	return w.info, err
+}
+
+// Prg loads a Commodore PRG file, which is a binary starting with its load address.
+func Prg(r io.Reader, mem munch.Addressable) (Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(data) < 2 {
+		return Info{}, errors.New("no load address")
+	}
+	w := &writer{mem: mem}
+	err = w.store(uint32(data[0])|uint32(data[1])<<8, data[2:])
+	return w.info, err
+}
+
+// records reads the lines of a text format, passing each non-blank one to fn until it returns
+// done.
+func records(r io.Reader, fn func(line string) (done bool, err error)) error {
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		done, err := fn(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if done {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("missing end of file record")
+}
+
+// decode returns the bytes of a record written in hex.
+func decode(s string) ([]uint8, error) {
+	bytes, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad hex in record: %w", err)
+	}
+	return bytes, nil
+}
+
+func be(bytes []uint8) uint32 {
+	var v uint32
+	for _, b := range bytes {
+		v = v<<8 | uint32(b)
+	}
+	return v
+}
+
+// IntelHex loads an Intel HEX file. The entry point comes from a start segment or start linear
+// address record.
+func IntelHex(r io.Reader, mem munch.Addressable) (Info, error) {
+	w := &writer{mem: mem}
+	var base uint32
+	err := records(r, func(line string) (bool, error) {
+		if line[0] != ':' {
+			return false, errors.New("record doesn't start with :")
+		}
+		rec, err := decode(line[1:])
+		if err != nil {
+			return false, err
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return false, errors.New("wrong record length")
+		}
+		var sum uint8
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0 {
+			return false, errors.New("bad checksum")
+		}
+
+		addr, data := be(rec[1:3]), rec[4:len(rec)-1]
+		switch rec[3] {
+		case 0x00:
+			return false, w.store(base+addr, data)
+		case 0x01:
+			return true, nil
+		case 0x02:
+			if len(data) != 2 {
+				return false, errors.New("wrong extended segment address length")
+			}
+			base = be(data) << 4
+		case 0x03:
+			if len(data) != 4 {
+				return false, errors.New("wrong start segment address length")
+			}
+			return false, w.entry(be(data[:2])<<4 + be(data[2:]))
+		case 0x04:
+			if len(data) != 2 {
+				return false, errors.New("wrong extended linear address length")
+			}
+			base = be(data) << 16
+		case 0x05:
+			if len(data) != 4 {
+				return false, errors.New("wrong start linear address length")
+			}
+			return false, w.entry(be(data))
+		default:
+			return false, fmt.Errorf("unknown record type %02x", rec[3])
+		}
+		return false, nil
+	})
+	return w.info, err
+}
+
+// SRecord loads Motorola S-records with 16, 24 or 32 bit addresses, as in S19, S28 and S37 files.
+// The entry point comes from the S7, S8 or S9 record that ends the file.
+func SRecord(r io.Reader, mem munch.Addressable) (Info, error) {
+	w := &writer{mem: mem}
+	err := records(r, func(line string) (bool, error) {
+		if len(line) < 2 || line[0] != 'S' {
+			return false, errors.New("record doesn't start with S")
+		}
+		kind := line[1]
+		rec, err := decode(line[2:])
+		if err != nil {
+			return false, err
+		}
+		if len(rec) < 1 || len(rec) != int(rec[0])+1 {
+			return false, errors.New("wrong record length")
+		}
+		var sum uint8
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0xff {
+			return false, errors.New("bad checksum")
+		}
+
+		size := map[byte]int{
+			'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2,
+		}[kind]
+		if size == 0 {
+			return false, fmt.Errorf("unknown record type S%c", kind)
+		}
+		if len(rec) < size+2 {
+			return false, errors.New("record too short for its address")
+		}
+		addr, data := be(rec[1:1+size]), rec[1+size:len(rec)-1]
+		switch kind {
+		case '1', '2', '3':
+			return false, w.store(addr, data)
+		case '7', '8', '9':
+			return true, w.entry(addr)
+		}
+		// S0 is a header and S5 and S6 count the records
+		return false, nil
+	})
+	return w.info, err
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/noddy76/munch"
+)
+
+var program = []uint8{0xa9, 0x01, 0x8d, 0x00, 0x02}
+
+func expectMemory(t *testing.T, mem munch.Addressable, addr uint16, expected []uint8) {
+	t.Helper()
+	got := make([]uint8, len(expected))
+	for i := range got {
+		got[i] = mem.Read(addr + uint16(i))
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("memory at $%04x is % x, expected % x", addr, got, expected)
+	}
+}
+
+func TestIntelHex(t *testing.T) {
+	ram := munch.NewRam(0x10000)
+	info, err := IntelHex(strings.NewReader(`
+:020000040000FA
+:05040000A9018D0002BE
+:0400000500000400F3
+:00000001FF
+`), ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMemory(t, ram, 0x0400, program)
+	if expected := (Info{Start: 0x0400, End: 0x0404, Entry: 0x0400, HasEntry: true}); info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+}
+
+func TestSRecord(t *testing.T) {
+	ram := munch.NewRam(0x10000)
+	info, err := SRecord(strings.NewReader(`S00800006D756E6368DC
+S1080400A9018D0002BA
+S20600FFF0010207
+S5030002FA
+S9030400F8
+`), ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMemory(t, ram, 0x0400, program)
+	expectMemory(t, ram, 0xfff0, []uint8{0x01, 0x02})
+	if expected := (Info{Start: 0x0400, End: 0xfff1, Entry: 0x0400, HasEntry: true}); info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+
+	info, err = SRecord(strings.NewReader("S804000400F7\n"), ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasEntry || info.Entry != 0x0400 {
+		t.Errorf("got %+v, expected entry $0400", info)
+	}
+}
+
+func TestPrgAndRaw(t *testing.T) {
+	ram := munch.NewRam(0x10000)
+	info, err := Prg(strings.NewReader(string(append([]uint8{0x01, 0x08}, program...))), ram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMemory(t, ram, 0x0801, program)
+	if expected := (Info{Start: 0x0801, End: 0x0805}); info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+
+	info, err = Raw(strings.NewReader(string(program)), ram, 0xfffb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMemory(t, ram, 0xfffb, program)
+	if expected := (Info{Start: 0xfffb, End: 0xffff}); info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.hex": ":05040000A9018D0002BE\n:00000001FF\n",
+		"a.s19": "S1080400A9018D0002BA\nS9030000FC\n",
+		"a.prg": string(append([]uint8{0x00, 0x04}, program...)),
+		"a.bin": string(program),
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// a ROM can only be loaded with Poke
+		bus := munch.NewBus()
+		bus.Addressable(0x0400, 0x04ff, munch.NewRom(make([]uint8, 0x100)))
+		if _, err := File(filepath.Join(dir, name), bus, 0x0400); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		expectMemory(t, bus, 0x0400, program)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	ram := munch.NewRam(0x10000)
+	for _, tc := range []struct {
+		name string
+		load func() error
+	}{
+		{"hex checksum", func() error {
+			_, err := IntelHex(strings.NewReader(":05040000A9018D0002BF\n:00000001FF\n"), ram)
+			return err
+		}},
+		{"hex without end", func() error {
+			_, err := IntelHex(strings.NewReader(":05040000A9018D0002BE\n"), ram)
+			return err
+		}},
+		{"hex past $ffff", func() error {
+			_, err := IntelHex(strings.NewReader(":020000040001F9\n:05040000A9018D0002BE\n:00000001FF\n"), ram)
+			return err
+		}},
+		{"hex wrapping past $ffffffff", func() error {
+			_, err := IntelHex(strings.NewReader(":02000004FFFFFC\n:02FFFE000102FE\n:00000001FF\n"), ram)
+			return err
+		}},
+		{"srec wrapping past $ffffffff", func() error {
+			_, err := SRecord(strings.NewReader("S307FFFFFFFE0102FA\nS9030000FC\n"), ram)
+			return err
+		}},
+		{"srec checksum", func() error {
+			_, err := SRecord(strings.NewReader("S1080400A9018D0002BB\nS9030000FC\n"), ram)
+			return err
+		}},
+		{"srec type", func() error {
+			_, err := SRecord(strings.NewReader("S4030000FC\n"), ram)
+			return err
+		}},
+		{"prg too short", func() error {
+			_, err := Prg(strings.NewReader("\x01"), ram)
+			return err
+		}},
+		{"raw past $ffff", func() error {
+			_, err := Raw(strings.NewReader(string(program)), ram, 0xfffc)
+			return err
+		}},
+	} {
+		if err := tc.load(); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
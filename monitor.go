@@ -0,0 +1,500 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrQuit is returned by Monitor.Exec for the quit command.
+var ErrQuit = errors.New("quit")
+
+// Monitor is a machine language monitor in the style of Wozmon for any machine built on a Bus. It
+// examines and changes memory with Peek and Poke so devices aren't disturbed.
+type Monitor struct {
+	// Limit is the most ticks go runs for, or zero to run until a breakpoint or halt.
+	Limit uint64
+
+	cpu      *Cpu6502
+	bus      *Bus
+	debugger *Debugger
+	out      io.Writer
+
+	next      uint16 // where examining and disassembling carry on from
+	deposit   uint16 // where depositing carries on from
+	interrupt int32
+}
+
+const monitorHelp = `addr              examine a byte
+addr.addr         examine a range
+.addr             examine from where the last examine finished
+addr: bb bb ...   deposit bytes, or carry on depositing with : bb bb ...
+d [addr[.addr]]   disassemble
+r [reg=nn ...]    show or set A, X, Y, SP, P and PC
+s [n]             step n instructions
+t [n]             trace n instructions
+g [addr]          go from addr or PC until a breakpoint, watchpoint or halt
+b [addr]          set a breakpoint, or list breakpoints and watchpoints
+w addr[.addr] [r|w|rw]  set a watchpoint
+bd id             delete a breakpoint or watchpoint
+l file addr       load a binary file at addr
+sv file addr.addr save a range to a binary file
+reset             reset the CPU
+q                 quit
+`
+
+// NewMonitor creates a monitor for cpu and the bus it is on, writing to out.
+func NewMonitor(cpu *Cpu6502, out io.Writer) *Monitor {
+	return &Monitor{cpu: cpu, bus: cpu.bus, debugger: NewDebugger(cpu), out: out, next: cpu.PC}
+}
+
+// Debugger returns the debugger holding the monitor's breakpoints.
+func (m *Monitor) Debugger() *Debugger { return m.debugger }
+
+// Interrupt stops go at the end of the instruction in progress. It is safe to call from another
+// goroutine, such as a signal handler.
+func (m *Monitor) Interrupt() {
+	atomic.StoreInt32(&m.interrupt, 1)
+}
+
+// Run reads commands from in until it ends or the quit command. Errors from commands are printed
+// and don't stop the monitor.
+func (m *Monitor) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(m.out, "* ")
+		if !scanner.Scan() {
+			fmt.Fprintln(m.out)
+			return scanner.Err()
+		}
+		if err := m.Exec(scanner.Text()); errors.Is(err, ErrQuit) {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(m.out, "? %v\n", err)
+		}
+	}
+}
+
+// Exec runs a single command.
+func (m *Monitor) Exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	cmd, args := strings.ToLower(fields[0]), fields[1:]
+
+	switch cmd {
+	case "?", "h", "help":
+		fmt.Fprint(m.out, monitorHelp)
+		return nil
+	case "q", "quit":
+		return ErrQuit
+	case "d":
+		return m.disassemble(args)
+	case "r":
+		return m.registers(args)
+	case "s", "t":
+		n, err := parseCount(args)
+		if err != nil {
+			return err
+		}
+		return m.step(n, cmd == "t")
+	case "g":
+		return m.goCmd(args)
+	case "b":
+		return m.breakCmd(args)
+	case "w":
+		return m.watch(args)
+	case "bd":
+		return m.deleteCmd(args)
+	case "l":
+		return m.load(args)
+	case "sv":
+		return m.save(args)
+	case "reset":
+		m.cpu.Reset()
+		m.next = m.cpu.PC
+		fmt.Fprintln(m.out, m.cpu.Registers())
+		return nil
+	}
+
+	if i := strings.Index(line, ":"); i >= 0 {
+		return m.depositCmd(line[:i], line[i+1:])
+	}
+	if len(fields) != 1 {
+		return fmt.Errorf("unknown command %q", fields[0])
+	}
+	return m.examine(fields[0])
+}
+
+// parseAddr parses a hex address with an optional $.
+func parseAddr(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(v), nil
+}
+
+// parseRange parses addr or addr.addr.
+func parseRange(s string) (uint16, uint16, error) {
+	from, to, found := strings.Cut(s, ".")
+	start, err := parseAddr(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return start, start, nil
+	}
+	end, err := parseAddr(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("range %q ends before it starts", s)
+	}
+	return start, end, nil
+}
+
+func parseCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad count %q", args[0])
+	}
+	return n, nil
+}
+
+func (m *Monitor) examine(arg string) error {
+	var start, end uint16
+	var err error
+	if strings.HasPrefix(arg, ".") {
+		start = m.next
+		end, err = parseAddr(arg[1:])
+		if err == nil && end < start {
+			err = fmt.Errorf("range ends before $%04x", start)
+		}
+	} else {
+		start, end, err = parseRange(arg)
+	}
+	if err != nil {
+		return err
+	}
+
+	for addr := uint32(start); addr <= uint32(end); addr++ {
+		if addr == uint32(start) || addr%8 == 0 {
+			if addr != uint32(start) {
+				fmt.Fprintln(m.out)
+			}
+			fmt.Fprintf(m.out, "%04X:", addr)
+		}
+		fmt.Fprintf(m.out, " %02X", m.bus.Peek(uint16(addr)))
+	}
+	fmt.Fprintln(m.out)
+	m.next = end + 1
+	return nil
+}
+
+func (m *Monitor) depositCmd(addr string, data string) error {
+	if addr = strings.TrimSpace(addr); addr != "" {
+		a, err := parseAddr(addr)
+		if err != nil {
+			return err
+		}
+		m.deposit = a
+	}
+	var bytes []uint8
+	for _, f := range strings.Fields(data) {
+		v, err := strconv.ParseUint(strings.TrimPrefix(f, "$"), 16, 8)
+		if err != nil {
+			return fmt.Errorf("bad byte %q", f)
+		}
+		bytes = append(bytes, uint8(v))
+	}
+	for _, v := range bytes {
+		m.bus.Poke(m.deposit, v)
+		m.deposit++
+	}
+	return nil
+}
+
+func (m *Monitor) disassemble(args []string) error {
+	start, end := m.next, uint16(0)
+	lines := 16
+	if len(args) > 0 {
+		var err error
+		if start, end, err = parseRange(args[0]); err != nil {
+			return err
+		}
+		if end != start {
+			lines = 0
+		}
+	}
+
+	addr := uint32(start)
+	for n := 0; lines == 0 && addr <= uint32(end) || n < lines; n++ {
+		fmt.Fprintln(m.out, m.disassembleAt(uint16(addr)))
+		_, size := m.cpu.Disassemble(uint16(addr))
+		addr += uint32(size)
+		if addr > 0xffff {
+			break
+		}
+	}
+	m.next = uint16(addr)
+	return nil
+}
+
+// disassembleAt formats the instruction at addr with its bytes.
+func (m *Monitor) disassembleAt(addr uint16) string {
+	text, size := m.cpu.Disassemble(addr)
+	var bytes []string
+	for i := uint16(0); i < size; i++ {
+		bytes = append(bytes, fmt.Sprintf("%02X", m.bus.Peek(addr+i)))
+	}
+	line := fmt.Sprintf("%04X  %-8s  %s", addr, strings.Join(bytes, " "), text)
+	if s := m.cpu.Symbols; s != nil {
+		if src, ok := s.Line(addr); ok {
+			line = fmt.Sprintf("%-36s; %s", line, src)
+		}
+		if name, ok := s.Name(addr); ok {
+			line = name + ":\n" + line
+		}
+	}
+	return line
+}
+
+func (m *Monitor) registers(args []string) error {
+	for _, arg := range args {
+		name, value, found := strings.Cut(arg, "=")
+		if !found {
+			return fmt.Errorf("expected reg=value, not %q", arg)
+		}
+		v, err := parseAddr(value)
+		if err != nil {
+			return err
+		}
+		if strings.ToUpper(name) != "PC" && v > 0xff {
+			return fmt.Errorf("$%x is too big for %s", v, name)
+		}
+		switch strings.ToUpper(name) {
+		case "A":
+			m.cpu.A = uint8(v)
+		case "X":
+			m.cpu.X = uint8(v)
+		case "Y":
+			m.cpu.Y = uint8(v)
+		case "SP", "S":
+			m.cpu.SP = uint8(v)
+		case "P":
+			m.cpu.P = uint8(v)
+		case "PC":
+			m.setPC(v)
+		default:
+			return fmt.Errorf("unknown register %q", name)
+		}
+	}
+	fmt.Fprintln(m.out, m.cpu.Registers())
+	return nil
+}
+
+// setPC moves the PC, abandoning any instruction part way through.
+func (m *Monitor) setPC(pc uint16) {
+	m.cpu.PC = pc
+	m.cpu.cycles = nil
+	m.cpu.step = 0
+	m.cpu.waitCycles = 0
+	m.next = pc
+}
+
+func (m *Monitor) step(n int, trace bool) error {
+	if trace {
+		tracer := m.cpu.Tracer
+		m.cpu.Tracer = NewTextTracer(m.out)
+		defer func() { m.cpu.Tracer = tracer }()
+	}
+	for i := 0; i < n; i++ {
+		stop := m.debugger.Step()
+		if stop.Reason != StopStep {
+			return m.stopped(stop)
+		}
+	}
+	if !trace {
+		fmt.Fprintln(m.out, m.cpu.Registers())
+	}
+	fmt.Fprintln(m.out, m.disassembleAt(m.cpu.PC))
+	m.next = m.cpu.PC
+	return nil
+}
+
+// goChunk is how many ticks go runs between checking for an interrupt.
+const goChunk = 100000
+
+func (m *Monitor) goCmd(args []string) error {
+	if len(args) > 0 {
+		pc, err := parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		m.setPC(pc)
+	}
+
+	atomic.StoreInt32(&m.interrupt, 0)
+	var ran uint64
+	for {
+		chunk := uint64(goChunk)
+		if m.Limit != 0 && m.Limit-ran < chunk {
+			chunk = m.Limit - ran
+		}
+		stop := m.debugger.Run(chunk)
+		ran += chunk
+		if stop.Reason != StopLimit || (m.Limit != 0 && ran >= m.Limit) {
+			return m.stopped(stop)
+		}
+		if atomic.LoadInt32(&m.interrupt) != 0 {
+			// finish the instruction so the CPU can be examined and carried on
+			for m.cpu.Waiting() {
+				m.bus.Tick()
+			}
+			fmt.Fprintf(m.out, "interrupted at $%04x\n", m.cpu.PC)
+			fmt.Fprintln(m.out, m.cpu.Registers())
+			m.next = m.cpu.PC
+			return nil
+		}
+	}
+}
+
+// stopped reports why running stopped.
+func (m *Monitor) stopped(stop Stop) error {
+	m.next = m.cpu.PC
+	if stop.Reason == StopError {
+		return stop.Err
+	}
+	fmt.Fprintln(m.out, stop)
+	fmt.Fprintln(m.out, m.cpu.Registers())
+	fmt.Fprintln(m.out, m.disassembleAt(m.cpu.PC))
+	return nil
+}
+
+func (m *Monitor) breakCmd(args []string) error {
+	if len(args) == 0 {
+		for _, bp := range m.debugger.Breakpoints() {
+			fmt.Fprintf(m.out, "%d  break $%04x  hits %d\n", bp.ID, bp.Addr, bp.Hits)
+		}
+		for _, wp := range m.debugger.Watchpoints() {
+			kind := ""
+			if wp.Read {
+				kind += "r"
+			}
+			if wp.Write {
+				kind += "w"
+			}
+			fmt.Fprintf(m.out, "%d  watch $%04x.$%04x %s  hits %d\n", wp.ID, wp.Start, wp.End, kind, wp.Hits)
+		}
+		return nil
+	}
+	addr, err := parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	bp := m.debugger.Break(addr)
+	fmt.Fprintf(m.out, "breakpoint %d at $%04x\n", bp.ID, addr)
+	return nil
+}
+
+func (m *Monitor) watch(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected an address to watch")
+	}
+	start, end, err := parseRange(args[0])
+	if err != nil {
+		return err
+	}
+	read, write := true, true
+	if len(args) > 1 {
+		switch strings.ToLower(args[1]) {
+		case "r":
+			write = false
+		case "w":
+			read = false
+		case "rw":
+		default:
+			return fmt.Errorf("expected r, w or rw, not %q", args[1])
+		}
+	}
+	wp := m.debugger.Watch(start, end, read, write)
+	fmt.Fprintf(m.out, "watchpoint %d on $%04x.$%04x\n", wp.ID, start, end)
+	return nil
+}
+
+func (m *Monitor) deleteCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected an id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("bad id %q", args[0])
+	}
+	if !m.debugger.Delete(id) {
+		return fmt.Errorf("no breakpoint or watchpoint %d", id)
+	}
+	return nil
+}
+
+func (m *Monitor) load(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a file and an address")
+	}
+	addr, err := parseAddr(args[1])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if len(data) > 0x10000-int(addr) {
+		return fmt.Errorf("%d bytes don't fit at $%04x", len(data), addr)
+	}
+	for i, v := range data {
+		m.bus.Poke(addr+uint16(i), v)
+	}
+	fmt.Fprintf(m.out, "loaded $%04x.$%04x\n", addr, int(addr)+len(data)-1)
+	return nil
+}
+
+func (m *Monitor) save(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a file and a range")
+	}
+	start, end, err := parseRange(args[1])
+	if err != nil {
+		return err
+	}
+	data := make([]uint8, 0, int(end)-int(start)+1)
+	for addr := uint32(start); addr <= uint32(end); addr++ {
+		data = append(data, m.bus.Peek(uint16(addr)))
+	}
+	return os.WriteFile(args[0], data, 0644)
+}
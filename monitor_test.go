@@ -0,0 +1,131 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestMonitor() (*Monitor, *Cpu6502, *bytes.Buffer) {
+	bus := NewBus()
+	bus.Addressable(0x0000, 0xffff, NewRam(0x10000))
+	cpu := NewCpu6502(bus)
+	var out bytes.Buffer
+	return NewMonitor(cpu, &out), cpu, &out
+}
+
+func TestMonitorMemory(t *testing.T) {
+	mon, _, out := newTestMonitor()
+	for _, tc := range []struct {
+		cmd      string
+		expected string
+	}{
+		{"0300: 01 02 03", ""},
+		{": 04 05", ""},
+		{"0300", "0300: 01\n"},
+		{".0304", "0301: 02 03 04 05\n"},
+		{"02fe.0301", "02FE: 00 00\n0300: 01 02\n"},
+		{"0400: a9 42 8d 00 02 ea", ""},
+		{"d 0400.0405", "0400  A9 42     LDA #$42\n0402  8D 00 02  STA $0200\n0405  EA        NOP\n"},
+	} {
+		out.Reset()
+		if err := mon.Exec(tc.cmd); err != nil {
+			t.Errorf("%q failed with %v", tc.cmd, err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("%q printed\n%s\nexpected\n%s", tc.cmd, out.String(), tc.expected)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "mem.bin")
+	if err := mon.Exec("sv " + file + " 0300.0304"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mon.Exec("l " + file + " 1000"); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	mon.Exec("1000.1004")
+	if out.String() != "1000: 01 02 03 04 05\n" {
+		t.Errorf("loaded %s", out.String())
+	}
+	if data, _ := os.ReadFile(file); len(data) != 5 {
+		t.Errorf("saved %d bytes", len(data))
+	}
+}
+
+func TestMonitorRun(t *testing.T) {
+	mon, cpu, out := newTestMonitor()
+	// LDX #$00, INX, BNE $0402, BRK
+	mon.Exec("0400: a2 00 e8 d0 fd 00")
+	mon.Exec("r pc=0400 a=12")
+	if cpu.PC != 0x0400 || cpu.A != 0x12 {
+		t.Fatalf("registers set to %v", cpu.Registers())
+	}
+
+	mon.Exec("s 2")
+	if cpu.PC != 0x0403 || cpu.X != 1 {
+		t.Errorf("stepped to %v", cpu.Registers())
+	}
+	out.Reset()
+	mon.Exec("t")
+	if !strings.HasPrefix(out.String(), "0403    BNE $0402") {
+		t.Errorf("traced %q", out.String())
+	}
+
+	mon.Exec("b 0405")
+	out.Reset()
+	mon.Exec("g")
+	if cpu.PC != 0x0405 || cpu.X != 0 || !strings.HasPrefix(out.String(), "breakpoint 1 at $0405") {
+		t.Errorf("went to %v printing %q", cpu.Registers(), out.String())
+	}
+
+	if err := mon.Exec("bd 1"); err != nil {
+		t.Error(err)
+	}
+	if err := mon.Exec("bd 1"); err == nil {
+		t.Error("deleted a breakpoint twice")
+	}
+	if err := mon.Exec("r q=1"); err == nil {
+		t.Error("set an unknown register")
+	}
+	if err := mon.Exec("q"); !errors.Is(err, ErrQuit) {
+		t.Errorf("quit returned %v", err)
+	}
+}
+
+func TestMonitorInterrupt(t *testing.T) {
+	mon, cpu, out := newTestMonitor()
+	mon.Exec("0400: 4c 00 04") // JMP $0400
+	mon.Interrupt()
+	mon.Limit = 3 * goChunk
+	mon.Exec("g 0400")
+	if !strings.HasPrefix(out.String(), "limit at $0400") {
+		t.Errorf("an interrupt before go stopped it: %q", out.String())
+	}
+
+	cpu.bus.Watch(0x0400, 0x0400, true, false, func(uint16, uint8, bool) { mon.Interrupt() })
+	out.Reset()
+	mon.Exec("g")
+	if !strings.HasPrefix(out.String(), "interrupted at $0400") || cpu.Waiting() {
+		t.Errorf("interrupting printed %q", out.String())
+	}
+}
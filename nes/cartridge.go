@@ -0,0 +1,258 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+// Package nes loads NES cartridges from iNES and NES 2.0 files, so munch can be the CPU of an NES
+// emulator.
+//
+// A Cartridge holds the PRG ROM, CHR ROM or RAM and PRG RAM, with a Mapper doing the bank
+// switching. Attach maps it on the CPU's bus at $6000-$ffff, and the PPU reads the pattern
+// tables through PPU. NROM, MMC1, UxROM, CNROM and MMC3 are supported.
+package nes
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/noddy76/munch"
+)
+
+// Mapper is the bank switching hardware on a cartridge. The PRG side sees the CPU's addresses
+// $6000-$ffff and the CHR side the PPU's addresses $0000-$1fff.
+type Mapper interface {
+	ReadPRG(addr uint16) uint8
+	WritePRG(addr uint16, v uint8)
+	ReadCHR(addr uint16) uint8
+	WriteCHR(addr uint16, v uint8)
+	// Mirroring is the current nametable arrangement, which some mappers can change.
+	Mirroring() Mirroring
+}
+
+// Cartridge is a loaded .nes file. It is an Addressable for the CPU's $6000-$ffff.
+type Cartridge struct {
+	Header  Header
+	PRG     []uint8 // PRG ROM
+	CHR     []uint8 // CHR ROM, or CHR RAM when the header gives no CHR ROM
+	PRGRAM  []uint8 // RAM at $6000-$7fff, including any battery backed RAM
+	Trainer []uint8 // copied to $7000 in PRGRAM as well
+	Mapper  Mapper
+
+	chrRAM bool
+	irq    *munch.InterruptLine
+}
+
+// LoadFile loads the named .nes file.
+func LoadFile(name string) (*Cartridge, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return c, nil
+}
+
+// Load reads a cartridge in iNES or NES 2.0 format.
+func Load(r io.Reader) (*Cartridge, error) {
+	header := make([]uint8, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrNotNES
+	}
+	h, err := ParseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if h.PRGROMSize == 0 {
+		return nil, errors.New("no PRG ROM")
+	}
+
+	c := &Cartridge{Header: h}
+	if h.Trainer {
+		c.Trainer = make([]uint8, 0x200)
+		if _, err := io.ReadFull(r, c.Trainer); err != nil {
+			return nil, errors.New("trainer is cut short")
+		}
+	}
+	// the mappers work in 8KB PRG and 1KB CHR banks, so round up any odd sizes
+	c.PRG = make([]uint8, roundUp(h.PRGROMSize, 0x2000))
+	if _, err := io.ReadFull(r, c.PRG[:h.PRGROMSize]); err != nil {
+		return nil, errors.New("PRG ROM is cut short")
+	}
+	if h.CHRROMSize > 0 {
+		c.CHR = make([]uint8, roundUp(h.CHRROMSize, 0x400))
+		if _, err := io.ReadFull(r, c.CHR[:h.CHRROMSize]); err != nil {
+			return nil, errors.New("CHR ROM is cut short")
+		}
+	} else {
+		size := h.CHRRAMSize
+		if size == 0 {
+			size = 0x2000
+		}
+		c.CHR = make([]uint8, roundUp(size, 0x400))
+		c.chrRAM = true
+	}
+
+	ram := h.PRGRAMSize + h.PRGNVRAMSize
+	if h.Trainer && ram < 0x2000 {
+		ram = 0x2000
+	}
+	c.PRGRAM = make([]uint8, ram)
+	if h.Trainer {
+		copy(c.PRGRAM[0x1000:], c.Trainer)
+	}
+
+	if c.Mapper, err = newMapper(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func roundUp(n int, unit int) int {
+	return (n + unit - 1) / unit * unit
+}
+
+// Attach maps the cartridge on the CPU's bus at $6000-$ffff and connects mappers that interrupt
+// the CPU, such as MMC3, to irq, which is usually cpu.IRQ.
+func (c *Cartridge) Attach(bus *munch.Bus, irq *munch.InterruptLine) *munch.Mapping {
+	c.irq = irq
+	if b, ok := c.Mapper.(banked); ok {
+		b.update()
+	}
+	return bus.Addressable(0x6000, 0xffff, c)
+}
+
+func (c *Cartridge) Read(addr uint16) uint8 {
+	return c.Mapper.ReadPRG(0x6000 + addr)
+}
+
+func (c *Cartridge) Write(addr uint16, v uint8) {
+	c.Mapper.WritePRG(0x6000+addr, v)
+}
+
+func (c *Cartridge) Peek(addr uint16) uint8 {
+	return c.Mapper.ReadPRG(0x6000 + addr)
+}
+
+// Poke writes PRG RAM, or patches PRG ROM, without touching the mapper's registers.
+func (c *Cartridge) Poke(addr uint16, v uint8) {
+	addr += 0x6000
+	if addr < 0x8000 {
+		if len(c.PRGRAM) > 0 {
+			c.PRGRAM[int(addr-0x6000)%len(c.PRGRAM)] = v
+		}
+		return
+	}
+	if b, ok := c.Mapper.(banked); ok {
+		c.PRG[b.prgIndex(addr)] = v
+	}
+}
+
+// PPU returns the cartridge as the PPU sees it, with the pattern tables at $0000-$1fff.
+func (c *Cartridge) PPU() munch.Addressable {
+	return &ppuSide{c}
+}
+
+// Mirroring is the current nametable arrangement, for the PPU.
+func (c *Cartridge) Mirroring() Mirroring {
+	return c.Mapper.Mirroring()
+}
+
+// Scanline is called by the PPU once per scanline while rendering, at dot 260 of each visible and
+// pre-render line. It clocks the MMC3's IRQ counter, standing in for the rising edges of PPU A12
+// the real chip counts, and does nothing for other mappers.
+func (c *Cartridge) Scanline() {
+	if s, ok := c.Mapper.(interface{ Scanline() }); ok {
+		s.Scanline()
+	}
+}
+
+type ppuSide struct {
+	c *Cartridge
+}
+
+func (p *ppuSide) Read(addr uint16) uint8     { return p.c.Mapper.ReadCHR(addr & 0x1fff) }
+func (p *ppuSide) Write(addr uint16, v uint8) { p.c.Mapper.WriteCHR(addr&0x1fff, v) }
+func (p *ppuSide) Peek(addr uint16) uint8     { return p.c.Mapper.ReadCHR(addr & 0x1fff) }
+
+// Poke patches CHR ROM as well as writing CHR RAM.
+func (p *ppuSide) Poke(addr uint16, v uint8) {
+	if b, ok := p.c.Mapper.(banked); ok {
+		p.c.CHR[b.chrIndex(addr&0x1fff)] = v
+	}
+}
+
+type cartridgeSnapshot struct {
+	PRGRAM    []uint8
+	CHRRAM    []uint8
+	Registers []byte
+}
+
+// Snapshot saves the RAM and the mapper's registers, so a Bus with the cartridge attached
+// includes it in its snapshots.
+func (c *Cartridge) Snapshot() ([]byte, error) {
+	s := cartridgeSnapshot{PRGRAM: c.PRGRAM}
+	if c.chrRAM {
+		s.CHRRAM = c.CHR
+	}
+	if r, ok := c.Mapper.(registered); ok {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(r.registers()); err != nil {
+			return nil, err
+		}
+		s.Registers = buf.Bytes()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Cartridge) Restore(data []byte) error {
+	var s cartridgeSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	chrRAM := 0
+	if c.chrRAM {
+		chrRAM = len(c.CHR)
+	}
+	if len(s.PRGRAM) != len(c.PRGRAM) || len(s.CHRRAM) != chrRAM {
+		return munch.ErrSnapshotMismatch
+	}
+	copy(c.PRGRAM, s.PRGRAM)
+	if c.chrRAM {
+		copy(c.CHR, s.CHRRAM)
+	}
+	if r, ok := c.Mapper.(registered); ok {
+		// gob leaves out zero values, so start from zero rather than the current registers
+		regs := reflect.ValueOf(r.registers()).Elem()
+		regs.Set(reflect.Zero(regs.Type()))
+		if err := gob.NewDecoder(bytes.NewReader(s.Registers)).Decode(r.registers()); err != nil {
+			return err
+		}
+	}
+	if b, ok := c.Mapper.(banked); ok {
+		b.update()
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/noddy76/munch"
+)
+
+func TestLoadErrors(t *testing.T) {
+	unsupported := nesFile(5, 0, 1, 1)
+	if _, err := Load(bytes.NewReader(unsupported)); !errors.Is(err, ErrUnsupportedMapper) {
+		t.Errorf("expected ErrUnsupportedMapper, got %v", err)
+	}
+	full := nesFile(0, 0, 1, 1)
+	for _, n := range []int{0, 10, HeaderSize + 0x1000, len(full) - 1} {
+		if _, err := Load(bytes.NewReader(full[:n])); err == nil {
+			t.Errorf("expected an error loading %d bytes", n)
+		}
+	}
+	if _, err := Load(bytes.NewReader(nesFile(0, 0, 0, 1))); err == nil {
+		t.Error("expected an error without PRG ROM")
+	}
+}
+
+func TestTrainer(t *testing.T) {
+	f := nesFile(0, 0x04, 1, 1)
+	trainer := bytes.Repeat([]uint8{0x5a}, 0x200)
+	f = append(f[:HeaderSize], append(trainer, f[HeaderSize:]...)...)
+	_, bus, _ := attach(t, f)
+	if v := bus.Read(0x7000); v != 0x5a {
+		t.Errorf("$7000 is $%02x, expected the trainer", v)
+	}
+	if v := bus.Read(0x7200); v != 0x00 {
+		t.Errorf("$7200 is $%02x, expected 0", v)
+	}
+	expectBanks(t, bus, [4]uint8{0, 1, 0, 1})
+}
+
+func TestCartridgeSnapshot(t *testing.T) {
+	file := nesFile(4, 0, 4, 0)
+	c, bus, irq := attach(t, file)
+	bus.Write(0x8000, 6)
+	bus.Write(0x8001, 3)
+	bus.Write(0x6000, 0x42)
+	c.PPU().Write(0x0000, 0x24)
+	bus.Write(0xc000, 0)
+	bus.Write(0xe001, 0)
+	c.Scanline()
+	if !irq.Asserted() {
+		t.Fatal("IRQ not asserted")
+	}
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, bus, irq = attach(t, file)
+	if err := c.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	expectBanks(t, bus, [4]uint8{3, 0, 6, 7})
+	if bus.Read(0x6000) != 0x42 || c.PPU().Read(0x0000) != 0x24 {
+		t.Error("RAM not restored")
+	}
+	if !irq.Asserted() {
+		t.Error("IRQ not restored")
+	}
+
+	other, _, _ := attach(t, nesFile(4, 0, 4, 1))
+	if err := other.Restore(data); !errors.Is(err, munch.ErrSnapshotMismatch) {
+		t.Errorf("expected ErrSnapshotMismatch restoring into a cartridge without CHR RAM, got %v", err)
+	}
+}
+
+func TestCartridgeRuns(t *testing.T) {
+	f := nesFile(0, 0, 1, 1)
+	prg := f[HeaderSize:]
+	copy(prg, []uint8{
+		0xa9, 0x42, //       LDA #$42
+		0x8d, 0x00, 0x60, // STA $6000
+		0x4c, 0x05, 0xc0, // JMP $c005
+	})
+	prg[0x3ffc], prg[0x3ffd] = 0x00, 0xc0
+
+	c, err := Load(bytes.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := munch.NewBus()
+	bus.AddressableMirrored(0x0000, 0x1fff, 0x800, munch.NewRam(0x800))
+	cpu := munch.NewCpu2A03(bus)
+	c.Attach(bus, cpu.IRQ)
+	cpu.Reset()
+	if cpu.PC != 0xc000 {
+		t.Fatalf("reset to $%04x, expected $c000", cpu.PC)
+	}
+	for i := 0; i < 20; i++ {
+		if err := bus.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.PRGRAM[0] != 0x42 {
+		t.Errorf("PRG RAM is $%02x, expected $42", c.PRGRAM[0])
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+import "errors"
+
+// Mirroring is how the PPU's four nametables are arranged in the console's 2KB of nametable RAM.
+type Mirroring int
+
+const (
+	// Horizontal mirroring has $2000 and $2400 sharing the first 1KB, for vertical scrolling.
+	Horizontal Mirroring = iota
+	// Vertical mirroring has $2000 and $2800 sharing the first 1KB, for horizontal scrolling.
+	Vertical
+	SingleScreenLower
+	SingleScreenUpper
+	// FourScreen uses 2KB of extra RAM on the cartridge for four separate nametables.
+	FourScreen
+)
+
+// Nametable maps a PPU address in $2000-$3eff to an offset in nametable RAM, which is 2KB, or
+// 4KB with FourScreen.
+func (m Mirroring) Nametable(addr uint16) uint16 {
+	table, offset := (addr>>10)&3, addr&0x3ff
+	switch m {
+	case Horizontal:
+		table >>= 1
+	case Vertical:
+		table &= 1
+	case SingleScreenLower:
+		table = 0
+	case SingleScreenUpper:
+		table = 1
+	}
+	return table<<10 | offset
+}
+
+// HeaderSize is the size of the iNES header at the start of a .nes file.
+const HeaderSize = 16
+
+// Header describes a cartridge, from the header of an iNES or NES 2.0 file.
+type Header struct {
+	NES20     bool // whether the header is in the NES 2.0 format
+	Mapper    int
+	Submapper int // always 0 for iNES
+
+	PRGROMSize   int // bytes of PRG ROM
+	CHRROMSize   int // bytes of CHR ROM, 0 if the cartridge has CHR RAM
+	PRGRAMSize   int // bytes of volatile PRG RAM
+	PRGNVRAMSize int // bytes of battery backed PRG RAM
+	CHRRAMSize   int // bytes of CHR RAM
+
+	Mirroring Mirroring
+	Battery   bool // whether there is battery backed memory
+	Trainer   bool // whether 512 bytes of data to load at $7000 follow the header
+}
+
+var ErrNotNES = errors.New("not an iNES file")
+
+// ParseHeader parses the 16 byte header of an iNES or NES 2.0 file. iNES headers don't give the
+// size of PRG RAM reliably, so they get 8KB, battery backed if Battery is set, and 8KB of CHR RAM
+// when there is no CHR ROM.
+func ParseHeader(b []uint8) (Header, error) {
+	if len(b) < HeaderSize || string(b[:4]) != "NES\x1a" {
+		return Header{}, ErrNotNES
+	}
+	h := Header{
+		NES20:   b[7]&0x0c == 0x08,
+		Mapper:  int(b[6] >> 4),
+		Battery: b[6]&0x02 != 0,
+		Trainer: b[6]&0x04 != 0,
+	}
+	switch {
+	case b[6]&0x08 != 0:
+		h.Mirroring = FourScreen
+	case b[6]&0x01 != 0:
+		h.Mirroring = Vertical
+	}
+
+	if !h.NES20 {
+		// Old tools wrote their name over bytes 7-15, so the high nibble of the mapper is only
+		// trusted when the end of the header is clear
+		if b[12]|b[13]|b[14]|b[15] == 0 {
+			h.Mapper |= int(b[7] & 0xf0)
+		}
+		h.PRGROMSize = int(b[4]) * 0x4000
+		h.CHRROMSize = int(b[5]) * 0x2000
+		if h.Battery {
+			h.PRGNVRAMSize = 0x2000
+		} else {
+			h.PRGRAMSize = 0x2000
+		}
+		if h.CHRROMSize == 0 {
+			h.CHRRAMSize = 0x2000
+		}
+		return h, nil
+	}
+
+	h.Mapper |= int(b[7]&0xf0) | int(b[8]&0x0f)<<8
+	h.Submapper = int(b[8] >> 4)
+	h.PRGROMSize = romSize(b[4], b[9]&0x0f, 0x4000)
+	h.CHRROMSize = romSize(b[5], b[9]>>4, 0x2000)
+	h.PRGRAMSize = ramSize(b[10] & 0x0f)
+	h.PRGNVRAMSize = ramSize(b[10] >> 4)
+	h.CHRRAMSize = ramSize(b[11]&0x0f) + ramSize(b[11]>>4)
+	if h.PRGROMSize < 0 || h.CHRROMSize < 0 {
+		return h, errors.New("ROM size too big")
+	}
+	return h, nil
+}
+
+// romSize works out a NES 2.0 ROM size from its low byte and high nibble. When the nibble is $f
+// the low byte is an exponent and multiplier instead.
+func romSize(lsb uint8, msb uint8, unit int) int {
+	if msb != 0x0f {
+		return (int(msb)<<8 | int(lsb)) * unit
+	}
+	exponent, multiplier := lsb>>2, int(lsb&3)*2+1
+	if exponent > 30 {
+		return -1
+	}
+	return multiplier << exponent
+}
+
+// ramSize works out a NES 2.0 RAM size from its shift count.
+func ramSize(shift uint8) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		header   string
+		expected Header
+	}{
+		{"iNES NROM", "NES\x1a\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+			Header{Mapper: 0, PRGROMSize: 0x8000, CHRROMSize: 0x2000, PRGRAMSize: 0x2000, Mirroring: Vertical}},
+		{"iNES MMC1 with CHR RAM and battery", "NES\x1a\x10\x00\x12\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+			Header{Mapper: 1, PRGROMSize: 0x40000, PRGNVRAMSize: 0x2000, CHRRAMSize: 0x2000, Battery: true}},
+		{"iNES high mapper nibble", "NES\x1a\x01\x01\x40\x40\x00\x00\x00\x00\x00\x00\x00\x00",
+			Header{Mapper: 0x44, PRGROMSize: 0x4000, CHRROMSize: 0x2000, PRGRAMSize: 0x2000}},
+		{"iNES DiskDude!", "NES\x1a\x01\x01\x40DiskDude!",
+			Header{Mapper: 4, PRGROMSize: 0x4000, CHRROMSize: 0x2000, PRGRAMSize: 0x2000}},
+		{"iNES four screen trainer", "NES\x1a\x01\x01\x0c\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+			Header{PRGROMSize: 0x4000, CHRROMSize: 0x2000, PRGRAMSize: 0x2000, Mirroring: FourScreen, Trainer: true}},
+		{"NES 2.0", "NES\x1a\x00\x00\x41\x08\x21\x01\x07\x79\x00\x00\x00\x00",
+			Header{NES20: true, Mapper: 0x104, Submapper: 2, PRGROMSize: 0x100 * 0x4000, CHRRAMSize: 0x2000 + 0x8000,
+				PRGRAMSize: 0x2000, Mirroring: Vertical}},
+		{"NES 2.0 exponent", "NES\x1a\x4b\x00\x00\x08\x00\x0f\x00\x00\x00\x00\x00\x00",
+			Header{NES20: true, PRGROMSize: 7 << 18}},
+	} {
+		h, err := ParseHeader([]uint8(tc.header))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if h != tc.expected {
+			t.Errorf("%s: got %+v, expected %+v", tc.name, h, tc.expected)
+		}
+	}
+
+	if _, err := ParseHeader([]uint8("NES\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")); !errors.Is(err, ErrNotNES) {
+		t.Errorf("expected ErrNotNES, got %v", err)
+	}
+}
+
+func TestNametable(t *testing.T) {
+	for _, tc := range []struct {
+		mirroring Mirroring
+		expected  [4]uint16
+	}{
+		{Horizontal, [4]uint16{0x000, 0x000, 0x400, 0x400}},
+		{Vertical, [4]uint16{0x000, 0x400, 0x000, 0x400}},
+		{SingleScreenLower, [4]uint16{0x000, 0x000, 0x000, 0x000}},
+		{SingleScreenUpper, [4]uint16{0x400, 0x400, 0x400, 0x400}},
+		{FourScreen, [4]uint16{0x000, 0x400, 0x800, 0xc00}},
+	} {
+		for i, expected := range tc.expected {
+			// $3000-$3eff mirrors $2000-$2eff
+			for _, base := range []uint16{0x2000, 0x3000} {
+				addr := base + uint16(i)*0x400 + 0x123
+				if got := tc.mirroring.Nametable(addr); got != expected+0x123 {
+					t.Errorf("mirroring %d maps $%04x to $%03x, expected $%03x", tc.mirroring, addr, got, expected+0x123)
+				}
+			}
+		}
+	}
+}
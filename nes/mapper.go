@@ -0,0 +1,183 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupportedMapper = errors.New("unsupported mapper")
+
+func newMapper(c *Cartridge) (Mapper, error) {
+	b := board{cart: c, mirroring: c.Header.Mirroring}
+	var m interface {
+		Mapper
+		banked
+	}
+	switch c.Header.Mapper {
+	case 0:
+		m = &nrom{board: b}
+	case 1:
+		m = newMMC1(b)
+	case 2:
+		m = &uxrom{board: b}
+	case 3:
+		m = &cnrom{board: b}
+	case 4:
+		m = &mmc3{board: b}
+	default:
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedMapper, c.Header.Mapper)
+	}
+	m.update()
+	return m, nil
+}
+
+// banked is implemented by the mappers in this package, which are all built on board.
+type banked interface {
+	// update sets the banks and mirroring from the mapper's registers.
+	update()
+	prgIndex(addr uint16) int
+	chrIndex(addr uint16) int
+}
+
+// registered is implemented by mappers with registers to save in snapshots.
+type registered interface {
+	// registers returns a pointer to the registers.
+	registers() interface{}
+}
+
+// board is the banking common to the mappers. PRG ROM is switched in 8KB banks at $8000, $a000,
+// $c000 and $e000 and CHR in 1KB banks, so mappers with bigger banks set several at once.
+type board struct {
+	cart      *Cartridge
+	prg       [4]int // offset in PRG of each bank
+	chr       [8]int // offset in CHR of each bank
+	mirroring Mirroring
+
+	ramDisabled bool
+}
+
+// setPRG makes bank n of size bytes visible at addr. Bank numbers wrap around the size of the
+// ROM like a mapper ignoring the bits it doesn't need.
+func (b *board) setPRG(addr uint16, size int, n int) {
+	for i := 0; i < size/0x2000; i++ {
+		b.prg[int(addr-0x8000)/0x2000+i] = (n*size + i*0x2000) % len(b.cart.PRG)
+	}
+}
+
+func (b *board) setCHR(addr uint16, size int, n int) {
+	for i := 0; i < size/0x400; i++ {
+		b.chr[int(addr)/0x400+i] = (n*size + i*0x400) % len(b.cart.CHR)
+	}
+}
+
+// prgBanks is the number of banks of size bytes in PRG ROM.
+func (b *board) prgBanks(size int) int {
+	if n := len(b.cart.PRG) / size; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (b *board) prgIndex(addr uint16) int { return b.prg[(addr-0x8000)/0x2000] + int(addr&0x1fff) }
+func (b *board) chrIndex(addr uint16) int { return b.chr[addr/0x400] + int(addr&0x3ff) }
+
+func (b *board) ReadPRG(addr uint16) uint8 {
+	switch {
+	case addr >= 0x8000:
+		return b.cart.PRG[b.prgIndex(addr)]
+	case addr >= 0x6000 && len(b.cart.PRGRAM) > 0 && !b.ramDisabled:
+		return b.cart.PRGRAM[int(addr-0x6000)%len(b.cart.PRGRAM)]
+	}
+	return 0
+}
+
+// WritePRG writes PRG RAM. Mappers handle writes to their registers themselves.
+func (b *board) WritePRG(addr uint16, v uint8) {
+	if addr >= 0x6000 && addr < 0x8000 && len(b.cart.PRGRAM) > 0 && !b.ramDisabled {
+		b.cart.PRGRAM[int(addr-0x6000)%len(b.cart.PRGRAM)] = v
+	}
+}
+
+func (b *board) ReadCHR(addr uint16) uint8 {
+	return b.cart.CHR[b.chrIndex(addr)]
+}
+
+func (b *board) WriteCHR(addr uint16, v uint8) {
+	if b.cart.chrRAM {
+		b.cart.CHR[b.chrIndex(addr)] = v
+	}
+}
+
+func (b *board) Mirroring() Mirroring { return b.mirroring }
+
+// nrom is mapper 0, with 16KB or 32KB of PRG ROM and 8KB of CHR and no bank switching.
+type nrom struct {
+	board
+}
+
+func (m *nrom) update() {
+	m.setPRG(0x8000, 0x4000, 0)
+	m.setPRG(0xc000, 0x4000, m.prgBanks(0x4000)-1)
+	m.setCHR(0x0000, 0x2000, 0)
+}
+
+// uxrom is mapper 2, which switches 16KB of PRG ROM at $8000 with the last 16KB fixed at $c000.
+type uxrom struct {
+	board
+	regs struct{ Bank uint8 }
+}
+
+func (m *uxrom) WritePRG(addr uint16, v uint8) {
+	if addr < 0x8000 {
+		m.board.WritePRG(addr, v)
+		return
+	}
+	m.regs.Bank = v
+	m.update()
+}
+
+func (m *uxrom) update() {
+	m.setPRG(0x8000, 0x4000, int(m.regs.Bank))
+	m.setPRG(0xc000, 0x4000, m.prgBanks(0x4000)-1)
+	m.setCHR(0x0000, 0x2000, 0)
+}
+
+func (m *uxrom) registers() interface{} { return &m.regs }
+
+// cnrom is mapper 3, which has NROM's PRG and switches 8KB of CHR ROM.
+type cnrom struct {
+	board
+	regs struct{ Bank uint8 }
+}
+
+func (m *cnrom) WritePRG(addr uint16, v uint8) {
+	if addr < 0x8000 {
+		m.board.WritePRG(addr, v)
+		return
+	}
+	m.regs.Bank = v
+	m.update()
+}
+
+func (m *cnrom) update() {
+	m.setPRG(0x8000, 0x4000, 0)
+	m.setPRG(0xc000, 0x4000, m.prgBanks(0x4000)-1)
+	m.setCHR(0x0000, 0x2000, int(m.regs.Bank))
+}
+
+func (m *cnrom) registers() interface{} { return &m.regs }
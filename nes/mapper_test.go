@@ -0,0 +1,260 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/noddy76/munch"
+)
+
+// nesFile builds an iNES file with every 8KB bank of PRG ROM and 1KB bank of CHR ROM filled with
+// its bank number.
+func nesFile(mapper int, flags6 uint8, prg16k int, chr8k int) []uint8 {
+	f := []uint8{'N', 'E', 'S', 0x1a, uint8(prg16k), uint8(chr8k), flags6 | uint8(mapper&0x0f)<<4,
+		uint8(mapper & 0xf0), 0, 0, 0, 0, 0, 0, 0, 0}
+	for i := 0; i < prg16k*0x4000; i++ {
+		f = append(f, uint8(i/0x2000))
+	}
+	for i := 0; i < chr8k*0x2000; i++ {
+		f = append(f, uint8(i/0x400))
+	}
+	return f
+}
+
+// attach loads a cartridge and maps it on a new bus.
+func attach(t *testing.T, file []uint8) (*Cartridge, *munch.Bus, *munch.InterruptLine) {
+	t.Helper()
+	c, err := Load(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := munch.NewBus()
+	irq := munch.NewInterruptLine()
+	c.Attach(bus, irq)
+	return c, bus, irq
+}
+
+// expectBanks checks which 8KB PRG banks are at $8000, $a000, $c000 and $e000.
+func expectBanks(t *testing.T, bus *munch.Bus, expected [4]uint8) {
+	t.Helper()
+	var got [4]uint8
+	for i := range got {
+		got[i] = bus.Read(0x8000 + uint16(i)*0x2000 + 0x1234)
+	}
+	if got != expected {
+		t.Errorf("PRG banks are %v, expected %v", got, expected)
+	}
+}
+
+// expectCHR checks which 1KB CHR banks are at $0000-$1fff.
+func expectCHR(t *testing.T, c *Cartridge, expected [8]uint8) {
+	t.Helper()
+	var got [8]uint8
+	for i := range got {
+		got[i] = c.PPU().Read(uint16(i)*0x400 + 0x123)
+	}
+	if got != expected {
+		t.Errorf("CHR banks are %v, expected %v", got, expected)
+	}
+}
+
+func TestNROM(t *testing.T) {
+	c, bus, _ := attach(t, nesFile(0, 0x01, 1, 1))
+	expectBanks(t, bus, [4]uint8{0, 1, 0, 1})
+	expectCHR(t, c, [8]uint8{0, 1, 2, 3, 4, 5, 6, 7})
+	if c.Mirroring() != Vertical {
+		t.Errorf("mirroring is %d, expected vertical", c.Mirroring())
+	}
+
+	bus.Write(0x6123, 0x42)
+	if v := bus.Read(0x6123); v != 0x42 {
+		t.Errorf("PRG RAM read $%02x, expected $42", v)
+	}
+
+	// writes don't change ROM but pokes do
+	bus.Write(0x8000, 0x55)
+	c.PPU().Write(0x0000, 0x55)
+	if bus.Read(0x8000) != 0 || c.PPU().Read(0x0000) != 0 {
+		t.Error("wrote ROM")
+	}
+	bus.Poke(0x8000, 0x55)
+	c.PPU().(munch.Poker).Poke(0x0000, 0x55)
+	if bus.Read(0xc000) != 0x55 || c.PPU().Read(0x0000) != 0x55 {
+		t.Error("didn't patch ROM")
+	}
+
+	_, bus, _ = attach(t, nesFile(0, 0, 2, 1))
+	expectBanks(t, bus, [4]uint8{0, 1, 2, 3})
+}
+
+func TestUxROM(t *testing.T) {
+	c, bus, _ := attach(t, nesFile(2, 0, 8, 0))
+	expectBanks(t, bus, [4]uint8{0, 1, 14, 15})
+	bus.Write(0x8000, 3)
+	expectBanks(t, bus, [4]uint8{6, 7, 14, 15})
+	bus.Write(0xffff, 9) // wraps around to bank 1
+	expectBanks(t, bus, [4]uint8{2, 3, 14, 15})
+
+	c.PPU().Write(0x1234, 0x42)
+	if v := c.PPU().Read(0x1234); v != 0x42 {
+		t.Errorf("CHR RAM read $%02x, expected $42", v)
+	}
+}
+
+func TestCNROM(t *testing.T) {
+	c, bus, _ := attach(t, nesFile(3, 0, 2, 4))
+	expectCHR(t, c, [8]uint8{0, 1, 2, 3, 4, 5, 6, 7})
+	bus.Write(0x8000, 2)
+	expectCHR(t, c, [8]uint8{16, 17, 18, 19, 20, 21, 22, 23})
+	expectBanks(t, bus, [4]uint8{0, 1, 2, 3})
+}
+
+func TestMMC1(t *testing.T) {
+	c, bus, _ := attach(t, nesFile(1, 0, 16, 16))
+	write := func(addr uint16, v uint8) {
+		for i := 0; i < 5; i++ {
+			bus.Write(addr, v>>i&1)
+		}
+	}
+
+	// powers up with the last bank fixed at $c000
+	expectBanks(t, bus, [4]uint8{0, 1, 30, 31})
+	write(0xe000, 5)
+	expectBanks(t, bus, [4]uint8{10, 11, 30, 31})
+
+	// first bank fixed at $8000
+	write(0x8000, 0x08)
+	expectBanks(t, bus, [4]uint8{0, 1, 10, 11})
+
+	// 32KB banks ignore the low bit
+	write(0x8000, 0x00)
+	expectBanks(t, bus, [4]uint8{8, 9, 10, 11})
+
+	// writing bit 7 resets the shift register and the PRG mode
+	bus.Write(0x8000, 1)
+	bus.Write(0x8000, 0x80)
+	expectBanks(t, bus, [4]uint8{10, 11, 30, 31})
+
+	write(0xa000, 3)
+	expectCHR(t, c, [8]uint8{8, 9, 10, 11, 12, 13, 14, 15})
+	write(0x8000, 0x1e)
+	write(0xc000, 5)
+	expectCHR(t, c, [8]uint8{12, 13, 14, 15, 20, 21, 22, 23})
+
+	for control, expected := range []Mirroring{SingleScreenLower, SingleScreenUpper, Vertical, Horizontal} {
+		write(0x8000, uint8(control))
+		if c.Mirroring() != expected {
+			t.Errorf("control $%02x gives mirroring %d, expected %d", control, c.Mirroring(), expected)
+		}
+	}
+
+	bus.Write(0x6000, 0x42)
+	write(0xe000, 0x10)
+	if v := bus.Read(0x6000); v != 0 {
+		t.Errorf("disabled PRG RAM read $%02x", v)
+	}
+	write(0xe000, 0x00)
+	if v := bus.Read(0x6000); v != 0x42 {
+		t.Errorf("PRG RAM read $%02x, expected $42", v)
+	}
+}
+
+func TestMMC1SUROM(t *testing.T) {
+	_, bus, _ := attach(t, nesFile(1, 0, 32, 0))
+	write := func(addr uint16, v uint8) {
+		for i := 0; i < 5; i++ {
+			bus.Write(addr, v>>i&1)
+		}
+	}
+	expectBanks(t, bus, [4]uint8{0, 1, 30, 31})
+	write(0xa000, 0x10)
+	expectBanks(t, bus, [4]uint8{32, 33, 62, 63})
+}
+
+func TestMMC3(t *testing.T) {
+	c, bus, _ := attach(t, nesFile(4, 0, 16, 16))
+	bank := func(r uint8, v uint8) {
+		bus.Write(0x8000, r)
+		bus.Write(0x8001, v)
+	}
+
+	bank(6, 5)
+	bank(7, 9)
+	expectBanks(t, bus, [4]uint8{5, 9, 30, 31})
+	bus.Write(0x8000, 0x40)
+	expectBanks(t, bus, [4]uint8{30, 9, 5, 31})
+
+	bank(0, 11) // the low bit is ignored
+	bank(1, 20)
+	bank(2, 40)
+	bank(3, 41)
+	bank(4, 42)
+	bank(5, 43)
+	expectCHR(t, c, [8]uint8{10, 11, 20, 21, 40, 41, 42, 43})
+	bus.Write(0x8000, 0x80)
+	expectCHR(t, c, [8]uint8{40, 41, 42, 43, 10, 11, 20, 21})
+
+	bus.Write(0xa000, 1)
+	if c.Mirroring() != Horizontal {
+		t.Errorf("mirroring is %d, expected horizontal", c.Mirroring())
+	}
+	bus.Write(0xa000, 0)
+	if c.Mirroring() != Vertical {
+		t.Errorf("mirroring is %d, expected vertical", c.Mirroring())
+	}
+}
+
+func TestMMC3Irq(t *testing.T) {
+	c, bus, irq := attach(t, nesFile(4, 0, 2, 1))
+	bus.Write(0xc000, 2) // latch
+	bus.Write(0xc001, 0) // reload
+	bus.Write(0xe001, 0) // enable
+
+	for line := 0; line < 3; line++ {
+		if irq.Asserted() {
+			t.Fatalf("IRQ asserted after %d scanlines", line)
+		}
+		c.Scanline()
+	}
+	if !irq.Asserted() {
+		t.Fatal("IRQ not asserted after 3 scanlines")
+	}
+
+	// acknowledged by disabling, then the counter reloads and carries on
+	bus.Write(0xe000, 0)
+	if irq.Asserted() {
+		t.Fatal("IRQ still asserted after $e000 write")
+	}
+	bus.Write(0xe001, 0)
+	for line := 0; line < 3; line++ {
+		c.Scanline()
+	}
+	if !irq.Asserted() {
+		t.Fatal("IRQ not asserted after reloading")
+	}
+
+	// a latch of 0 interrupts on every scanline
+	bus.Write(0xe000, 0)
+	bus.Write(0xc000, 0)
+	bus.Write(0xc001, 0)
+	bus.Write(0xe001, 0)
+	c.Scanline()
+	if !irq.Asserted() {
+		t.Fatal("IRQ not asserted with a latch of 0")
+	}
+}
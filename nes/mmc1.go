@@ -0,0 +1,101 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+// mmc1 is mapper 1, the Nintendo MMC1. Its registers are written a bit at a time through a shift
+// register: five writes of bit 0 to $8000-$ffff load the register chosen by the address of the
+// last, and a write with bit 7 set resets the shift register.
+type mmc1 struct {
+	board
+	regs mmc1Registers
+}
+
+type mmc1Registers struct {
+	Shift   uint8
+	Count   uint8 // bits in Shift
+	Control uint8 // mirroring, PRG mode and CHR mode
+	CHR0    uint8
+	CHR1    uint8
+	PRG     uint8 // PRG bank and RAM disable
+}
+
+func newMMC1(b board) *mmc1 {
+	// starts with the last bank fixed at $c000 so the reset vector is there
+	return &mmc1{board: b, regs: mmc1Registers{Control: 0x0c}}
+}
+
+func (m *mmc1) WritePRG(addr uint16, v uint8) {
+	if addr < 0x8000 {
+		m.board.WritePRG(addr, v)
+		return
+	}
+	r := &m.regs
+	if v&0x80 != 0 {
+		r.Shift, r.Count = 0, 0
+		r.Control |= 0x0c
+		m.update()
+		return
+	}
+	r.Shift |= (v & 1) << r.Count
+	r.Count++
+	if r.Count < 5 {
+		return
+	}
+	switch addr & 0xe000 {
+	case 0x8000:
+		r.Control = r.Shift
+	case 0xa000:
+		r.CHR0 = r.Shift
+	case 0xc000:
+		r.CHR1 = r.Shift
+	case 0xe000:
+		r.PRG = r.Shift
+	}
+	r.Shift, r.Count = 0, 0
+	m.update()
+}
+
+func (m *mmc1) update() {
+	r := &m.regs
+	m.mirroring = [4]Mirroring{SingleScreenLower, SingleScreenUpper, Vertical, Horizontal}[r.Control&3]
+
+	if r.Control&0x10 == 0 {
+		m.setCHR(0x0000, 0x2000, int(r.CHR0>>1))
+	} else {
+		m.setCHR(0x0000, 0x1000, int(r.CHR0))
+		m.setCHR(0x1000, 0x1000, int(r.CHR1))
+	}
+
+	// 512KB boards such as SUROM use bit 4 of the CHR register to choose a 256KB half
+	outer := 0
+	if len(m.cart.PRG) > 0x40000 {
+		outer = int(r.CHR0 & 0x10)
+	}
+	bank := outer + int(r.PRG&0x0f)
+	switch r.Control >> 2 & 3 {
+	case 0, 1:
+		m.setPRG(0x8000, 0x8000, bank>>1)
+	case 2:
+		m.setPRG(0x8000, 0x4000, outer)
+		m.setPRG(0xc000, 0x4000, bank)
+	case 3:
+		m.setPRG(0x8000, 0x4000, bank)
+		m.setPRG(0xc000, 0x4000, outer+0x0f)
+	}
+	m.ramDisabled = r.PRG&0x10 != 0
+}
+
+func (m *mmc1) registers() interface{} { return &m.regs }
@@ -0,0 +1,123 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package nes
+
+// mmc3 is mapper 4, the Nintendo MMC3, with two 8KB PRG ROM banks, six CHR banks and an IRQ
+// counter clocked by Scanline.
+type mmc3 struct {
+	board
+	regs mmc3Registers
+}
+
+type mmc3Registers struct {
+	Select    uint8    // bank register to write, PRG mode and CHR A12 inversion
+	Banks     [8]uint8 // R0-R7
+	Mirroring uint8
+
+	Latch   uint8
+	Counter uint8
+	Reload  bool // reload the counter on the next clock
+	Enabled bool
+	IRQ     bool // asserting IRQ
+}
+
+// WritePRG writes the registers, which are selected by the address range and whether the address
+// is even or odd. The PRG RAM protect register at $a001 is ignored like most emulators do, since
+// the MMC6 uses the same bits differently.
+func (m *mmc3) WritePRG(addr uint16, v uint8) {
+	if addr < 0x8000 {
+		m.board.WritePRG(addr, v)
+		return
+	}
+	r := &m.regs
+	switch addr & 0xe001 {
+	case 0x8000:
+		r.Select = v
+	case 0x8001:
+		r.Banks[r.Select&7] = v
+	case 0xa000:
+		r.Mirroring = v
+	case 0xc000:
+		r.Latch = v
+	case 0xc001:
+		r.Counter = 0
+		r.Reload = true
+	case 0xe000:
+		r.Enabled = false
+		r.IRQ = false
+	case 0xe001:
+		r.Enabled = true
+	}
+	m.update()
+}
+
+// Scanline clocks the IRQ counter. It is reloaded from the latch when it reaches zero or after a
+// write to $c001, and otherwise counts down, asserting IRQ when it gets to zero while enabled.
+func (m *mmc3) Scanline() {
+	r := &m.regs
+	if r.Counter == 0 || r.Reload {
+		r.Counter = r.Latch
+		r.Reload = false
+	} else {
+		r.Counter--
+	}
+	if r.Counter == 0 && r.Enabled {
+		r.IRQ = true
+		m.updateIRQ()
+	}
+}
+
+func (m *mmc3) update() {
+	r := &m.regs
+	last := m.prgBanks(0x2000) - 1
+	if r.Select&0x40 == 0 {
+		m.setPRG(0x8000, 0x2000, int(r.Banks[6]))
+		m.setPRG(0xc000, 0x2000, last-1)
+	} else {
+		m.setPRG(0x8000, 0x2000, last-1)
+		m.setPRG(0xc000, 0x2000, int(r.Banks[6]))
+	}
+	m.setPRG(0xa000, 0x2000, int(r.Banks[7]))
+	m.setPRG(0xe000, 0x2000, last)
+
+	// the 2KB banks are at $0000 and the 1KB banks at $1000, or the other way round when A12 is
+	// inverted
+	var invert uint16
+	if r.Select&0x80 != 0 {
+		invert = 0x1000
+	}
+	m.setCHR(invert, 0x800, int(r.Banks[0]>>1))
+	m.setCHR(invert+0x800, 0x800, int(r.Banks[1]>>1))
+	for i := 0; i < 4; i++ {
+		m.setCHR((invert^0x1000)+uint16(i)*0x400, 0x400, int(r.Banks[2+i]))
+	}
+
+	if m.cart.Header.Mirroring != FourScreen {
+		m.mirroring = Vertical
+		if r.Mirroring&1 != 0 {
+			m.mirroring = Horizontal
+		}
+	}
+	m.updateIRQ()
+}
+
+func (m *mmc3) updateIRQ() {
+	if m.cart.irq != nil {
+		m.cart.irq.Set(m, m.regs.IRQ)
+	}
+}
+
+func (m *mmc3) registers() interface{} { return &m.regs }
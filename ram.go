@@ -34,6 +34,9 @@ func (r *Ram) Write(addr uint16, v uint8) {
 	r.bytes[int(addr)%len(r.bytes)] = v
 }
 
+func (r *Ram) Peek(addr uint16) uint8    { return r.Read(addr) }
+func (r *Ram) Poke(addr uint16, v uint8) { r.Write(addr, v) }
+
 type Rom struct {
 	bytes []uint8
 }
@@ -49,3 +52,10 @@ func (r *Rom) Read(addr uint16) uint8 {
 }
 
 func (r *Rom) Write(addr uint16, v uint8) {}
+
+func (r *Rom) Peek(addr uint16) uint8 { return r.Read(addr) }
+
+// Poke patches the ROM.
+func (r *Rom) Poke(addr uint16, v uint8) {
+	r.bytes[int(addr)%len(r.bytes)] = v
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNoHistory = errors.New("not enough history to rewind")
+
+// MemoryWrite is a write made through the bus by an instruction.
+type MemoryWrite struct {
+	Addr  uint16
+	Value uint8
+}
+
+// rewindEntry is the point just before an instruction starts, and the writes it went on to make.
+type rewindEntry struct {
+	tick   uint64
+	pc     uint16
+	writes []MemoryWrite
+}
+
+type rewindCheckpoint struct {
+	index    int // of the entry the snapshot was taken at
+	snapshot []byte
+}
+
+// Rewind records the history of a machine so it can be stepped backwards. A snapshot of the bus
+// is taken every interval instructions and the writes each instruction makes are logged. Going
+// back restores the nearest snapshot and runs forward again to the instruction wanted, so devices
+// should implement Snapshotter to be rewound with the rest of the machine.
+type Rewind struct {
+	bus *Bus
+	cpu *Cpu6502
+
+	interval    int
+	checkpoints []rewindCheckpoint
+	limit       int // checkpoints kept
+
+	entries []rewindEntry
+	first   int // index of entries[0] since recording started
+
+	watch     *Watch
+	ticker    *TickerHandle
+	replaying bool
+	stopped   bool
+}
+
+// NewRewind starts recording the machine cpu is on, keeping enough history for checkpoints
+// snapshots taken every interval instructions.
+func NewRewind(cpu *Cpu6502, interval int, checkpoints int) *Rewind {
+	if interval < 1 {
+		interval = 1
+	}
+	if checkpoints < 1 {
+		checkpoints = 1
+	}
+	r := &Rewind{bus: cpu.bus, cpu: cpu, interval: interval, limit: checkpoints}
+	r.watch = r.bus.Watch(0x0000, 0xffff, false, true, func(addr uint16, v uint8, write bool) {
+		if r.replaying || len(r.entries) == 0 {
+			return
+		}
+		e := &r.entries[len(r.entries)-1]
+		e.writes = append(e.writes, MemoryWrite{Addr: addr, Value: v})
+	})
+	r.ticker = r.bus.Ticker(r)
+	return r
+}
+
+// Stop ends recording and drops the history.
+func (r *Rewind) Stop() {
+	r.watch.Remove()
+	r.ticker.Remove()
+	r.stopped = true
+	r.entries = nil
+	r.checkpoints = nil
+}
+
+// Tick records the start of each instruction, it is called by the bus after the CPU has ticked.
+func (r *Rewind) Tick() error {
+	cpu := r.cpu
+	if r.stopped || r.replaying || cpu.Waiting() || cpu.waiting || cpu.halted {
+		return nil
+	}
+
+	index := r.first + len(r.entries)
+	r.entries = append(r.entries, rewindEntry{tick: r.bus.TickCount(), pc: cpu.PC})
+	if len(r.checkpoints) == 0 || index%r.interval == 0 {
+		snapshot, err := r.bus.Snapshot()
+		if err != nil {
+			return err
+		}
+		r.checkpoints = append(r.checkpoints, rewindCheckpoint{index: index, snapshot: snapshot})
+		r.trim()
+	}
+	return nil
+}
+
+// trim drops the oldest checkpoint and the entries that need it once there are too many.
+func (r *Rewind) trim() {
+	if len(r.checkpoints) <= r.limit {
+		return
+	}
+	r.checkpoints = r.checkpoints[1:]
+	drop := r.checkpoints[0].index - r.first
+	r.entries = append([]rewindEntry(nil), r.entries[drop:]...)
+	r.first += drop
+}
+
+// current is the index of the entry for the instruction in progress, or about to start.
+func (r *Rewind) current() int {
+	return r.first + len(r.entries) - 1
+}
+
+// atStart reports whether the machine is at the start of the latest entry rather than part way
+// through its instruction.
+func (r *Rewind) atStart() bool {
+	return len(r.entries) > 0 && r.entries[len(r.entries)-1].tick == r.bus.TickCount()
+}
+
+// Len is the number of instructions that can be stepped back.
+func (r *Rewind) Len() int {
+	if r.atStart() {
+		return len(r.entries) - 1
+	}
+	return len(r.entries)
+}
+
+// StepBack puts the machine back to the start of the instruction n instructions ago. Part way
+// through an instruction, StepBack(1) goes back to its start.
+func (r *Rewind) StepBack(n int) error {
+	if n < 1 {
+		return nil
+	}
+	if n > r.Len() {
+		return fmt.Errorf("%w: %d instructions back, %d recorded", ErrNoHistory, n, r.Len())
+	}
+	target := r.current() - n
+	if !r.atStart() {
+		target++
+	}
+	return r.goTo(target)
+}
+
+// RunBackToWrite puts the machine back to the start of the last instruction to write addr,
+// returning the value it wrote.
+func (r *Rewind) RunBackToWrite(addr uint16) (uint8, error) {
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		writes := r.entries[i].writes
+		for j := len(writes) - 1; j >= 0; j-- {
+			if writes[j].Addr == addr {
+				v := writes[j].Value
+				return v, r.goTo(r.first + i)
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: no write to $%04x recorded", ErrNoHistory, addr)
+}
+
+// Writes returns the writes made by the instruction StepBack(n) would go back to the start of.
+// Writes(0) is the instruction in progress.
+func (r *Rewind) Writes(n int) []MemoryWrite {
+	i := len(r.entries) - 1 - n
+	if i < 0 || i >= len(r.entries) {
+		return nil
+	}
+	return r.entries[i].writes
+}
+
+// goTo restores the machine to the start of entry index, forgetting everything after it.
+func (r *Rewind) goTo(index int) error {
+	var cp *rewindCheckpoint
+	for i := len(r.checkpoints) - 1; i >= 0; i-- {
+		if r.checkpoints[i].index <= index {
+			cp = &r.checkpoints[i]
+			break
+		}
+	}
+	if cp == nil || index < r.first {
+		return fmt.Errorf("%w: instruction %d has been dropped", ErrNoHistory, index)
+	}
+
+	// Replaying shouldn't look like new accesses to anything watching the bus
+	target := r.entries[index-r.first].tick
+	watches := r.bus.watches
+	r.bus.watches = nil
+	r.replaying = true
+	defer func() {
+		r.bus.watches = watches
+		r.replaying = false
+	}()
+	if err := r.bus.Restore(cp.snapshot); err != nil {
+		return err
+	}
+	for r.bus.TickCount() < target {
+		if err := r.bus.Tick(); err != nil {
+			return err
+		}
+	}
+
+	r.entries = r.entries[:index-r.first+1]
+	r.entries[len(r.entries)-1].writes = nil
+	for len(r.checkpoints) > 0 && r.checkpoints[len(r.checkpoints)-1].index > index {
+		r.checkpoints = r.checkpoints[:len(r.checkpoints)-1]
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRewindStepBack(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		bus, cpu, ram := newFunctionalTestMachine(t, accurate)
+		rewind := NewRewind(cpu, 100, 10)
+
+		// the state at the start of each instruction
+		var starts []Registers
+		var ticks []uint64
+		for len(starts) < 5000 {
+			bus.Tick()
+			if !cpu.Waiting() {
+				starts = append(starts, cpu.Registers())
+				ticks = append(ticks, bus.TickCount())
+			}
+		}
+		expected := string(ram.bytes)
+		end := bus.TickCount()
+
+		for _, n := range []int{1, 7, 150} {
+			if err := rewind.StepBack(n); err != nil {
+				t.Fatal(err)
+			}
+			starts, ticks = starts[:len(starts)-n], ticks[:len(ticks)-n]
+			if cpu.Registers() != starts[len(starts)-1] || bus.TickCount() != ticks[len(ticks)-1] {
+				t.Fatalf("stepped back %d to %v at tick %d, expected %v at tick %d",
+					n, cpu.Registers(), bus.TickCount(), starts[len(starts)-1], ticks[len(ticks)-1])
+			}
+		}
+
+		// running forward again ends up in the same place
+		for bus.TickCount() < end {
+			bus.Tick()
+		}
+		if string(ram.bytes) != expected {
+			t.Errorf("memory differs after running forward again")
+		}
+
+		if err := rewind.StepBack(rewind.Len() + 1); !errors.Is(err, ErrNoHistory) {
+			t.Errorf("stepped back past the history with %v", err)
+		}
+		if rewind.Len() > 10*100 {
+			t.Errorf("kept %d instructions of history", rewind.Len())
+		}
+	}
+}
+
+func TestRewindRunBackToWrite(t *testing.T) {
+	bus, cpu, ram := newFunctionalTestMachine(t, false)
+	rewind := NewRewind(cpu, 50, 100)
+
+	var lastPC uint16
+	var lastValue uint8
+	var pc uint16
+	bus.Watch(0x0200, 0x0200, false, true, func(addr uint16, v uint8, write bool) {
+		lastPC, lastValue = pc, v
+	})
+	for i := 0; i < 3000; i++ {
+		pc = cpu.PC
+		bus.Tick()
+		for cpu.Waiting() {
+			bus.Tick()
+		}
+	}
+	if lastPC == 0 {
+		t.Fatal("nothing written to $0200")
+	}
+
+	v, err := rewind.RunBackToWrite(0x0200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu.PC != lastPC || v != lastValue {
+		t.Errorf("ran back to $%04x writing $%02x, expected $%04x writing $%02x", cpu.PC, v, lastPC, lastValue)
+	}
+
+	bus.Tick()
+	if ram.bytes[0x0200] != lastValue {
+		t.Errorf("$0200 is $%02x after running the write again", ram.bytes[0x0200])
+	}
+	if writes := rewind.Writes(0); len(writes) == 0 || writes[len(writes)-1].Addr != 0x0200 {
+		t.Errorf("recorded writes %v", writes)
+	}
+
+	rewind.Stop()
+	if _, err := rewind.RunBackToWrite(0x0200); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("ran back after stopping with %v", err)
+	}
+	if len(bus.tickers) != 1 {
+		t.Errorf("%d tickers left after stopping", len(bus.tickers))
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// singleStepDir holds the 65x02 SingleStepTests, from https://github.com/SingleStepTests/65x02,
+// with the NMOS tests in 6502/v1 and the WDC tests in wdc65c02/v1. MUNCH_SINGLESTEP overrides it.
+func singleStepDir() string {
+	if dir := os.Getenv("MUNCH_SINGLESTEP"); dir != "" {
+		return dir
+	}
+	return "functional_tests/65x02"
+}
+
+type singleStepState struct {
+	PC  uint16   `json:"pc"`
+	S   uint8    `json:"s"`
+	A   uint8    `json:"a"`
+	X   uint8    `json:"x"`
+	Y   uint8    `json:"y"`
+	P   uint8    `json:"p"`
+	RAM [][2]int `json:"ram"`
+}
+
+func (s singleStepState) registers() Registers {
+	return Registers{A: s.A, X: s.X, Y: s.Y, SP: s.S, P: s.P, PC: s.PC}
+}
+
+// singleStepCycle is a bus access, which is written as [address, value, "read" or "write"]
+type singleStepCycle busAccess
+
+func (c *singleStepCycle) UnmarshalJSON(data []byte) error {
+	var v [3]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	addr, ok1 := v[0].(float64)
+	value, ok2 := v[1].(float64)
+	kind, ok3 := v[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("bad cycle %s", data)
+	}
+	*c = singleStepCycle{addr: uint16(addr), v: uint8(value), write: kind == "write"}
+	return nil
+}
+
+type singleStepCase struct {
+	Name    string            `json:"name"`
+	Initial singleStepState   `json:"initial"`
+	Final   singleStepState   `json:"final"`
+	Cycles  []singleStepCycle `json:"cycles"`
+}
+
+// runSingleStepCase runs the instruction and describes how it differs from the expected result.
+func runSingleStepCase(variant Variant, tc *singleStepCase) []string {
+	bus := NewBus()
+	rec := &recorder{ram: NewRam(0x10000), bus: bus}
+	bus.Addressable(0x0000, 0xffff, rec)
+	cpu := newCpu(bus, variant)
+	cpu.CycleAccurate = true
+	for _, m := range tc.Initial.RAM {
+		rec.ram.Write(uint16(m[0]), uint8(m[1]))
+	}
+	cpu.A, cpu.X, cpu.Y = tc.Initial.A, tc.Initial.X, tc.Initial.Y
+	cpu.SP, cpu.P, cpu.PC = tc.Initial.S, tc.Initial.P, tc.Initial.PC
+	rec.accesses = nil
+
+	step(bus, cpu)
+
+	var diffs []string
+	if regs, expected := cpu.Registers(), tc.Final.registers(); regs != expected {
+		diffs = append(diffs, fmt.Sprintf("registers %v, expected %v", regs, expected))
+	}
+	for _, m := range tc.Final.RAM {
+		if v := rec.ram.Read(uint16(m[0])); v != uint8(m[1]) {
+			diffs = append(diffs, fmt.Sprintf("$%04x is $%02x, expected $%02x", m[0], v, m[1]))
+		}
+	}
+	if len(rec.accesses) != len(tc.Cycles) {
+		diffs = append(diffs, fmt.Sprintf("took %d cycles, expected %d", len(rec.accesses), len(tc.Cycles)))
+	}
+	for i := 0; i < len(rec.accesses) && i < len(tc.Cycles); i++ {
+		got, expected := rec.accesses[i], busAccess(tc.Cycles[i])
+		if got.addr != expected.addr || got.v != expected.v || got.write != expected.write {
+			diffs = append(diffs, fmt.Sprintf("cycle %d is %v, expected %v", i+1, got, expected))
+			break
+		}
+	}
+	return diffs
+}
+
+func runSingleStepTests(t *testing.T, variant Variant, dir string) {
+	files, _ := filepath.Glob(filepath.Join(singleStepDir(), dir, "*.json"))
+	if len(files) == 0 {
+		t.Skipf("no tests in %s", filepath.Join(singleStepDir(), dir))
+	}
+
+	opcodes := newCpu(NewBus(), variant).opCodes
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		var opcode int
+		if _, err := fmt.Sscanf(name, "%x", &opcode); err != nil || opcode > 0xff {
+			continue
+		}
+		switch mne := opcodes[opcode].mne; mne {
+		case "JAM*", "STP", "WAI":
+			// these never finish, so there is no instruction to compare
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cases []singleStepCase
+			if err := json.Unmarshal(data, &cases); err != nil {
+				t.Fatal(err)
+			}
+			if testing.Short() && len(cases) > 100 {
+				cases = cases[:100]
+			}
+
+			failed := 0
+			for i := range cases {
+				diffs := runSingleStepCase(variant, &cases[i])
+				if len(diffs) == 0 {
+					continue
+				}
+				if failed < 5 {
+					t.Errorf("%s %s: %s", opcodes[opcode].mne, cases[i].Name, strings.Join(diffs, ", "))
+				}
+				failed++
+			}
+			if failed > 0 {
+				t.Errorf("%s failed %d of %d cases", opcodes[opcode].mne, failed, len(cases))
+			}
+		})
+	}
+}
+
+func TestSingleStep6502(t *testing.T) {
+	runSingleStepTests(t, NMOS6502, "6502/v1")
+}
+
+func TestSingleStep65C02(t *testing.T) {
+	runSingleStepTests(t, WDC65C02, "wdc65c02/v1")
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Snapshotter is implemented by anything whose state can be saved and restored. Devices mapped on
+// a Bus or registered as Tickers are included in the Bus's snapshot if they implement it.
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// SnapshotVersion is the version of the snapshot format written by WriteSnapshot.
+const SnapshotVersion = 1
+
+var snapshotMagic = [8]byte{'M', 'U', 'N', 'C', 'H', 'S', 'N', 'P'}
+
+var ErrSnapshotMismatch = errors.New("snapshot does not match the machine")
+
+// WriteSnapshot writes the snapshot of s, usually a Bus, to w with a header identifying the
+// format and its version.
+func WriteSnapshot(w io.Writer, s Snapshotter) error {
+	data, err := s.Snapshot()
+	if err != nil {
+		return err
+	}
+	header := struct {
+		Magic   [8]byte
+		Version uint32
+		Length  uint32
+	}{snapshotMagic, SnapshotVersion, uint32(len(data))}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot and restores it into s.
+func ReadSnapshot(r io.Reader, s Snapshotter) error {
+	var header struct {
+		Magic   [8]byte
+		Version uint32
+		Length  uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if header.Magic != snapshotMagic {
+		return errors.New("not a munch snapshot")
+	}
+	if header.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	data := make([]byte, header.Length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return s.Restore(data)
+}
+
+func encodeSnapshot(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSnapshot(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type mappingSnapshot struct {
+	Start    uint16
+	End      uint16
+	Mask     uint16
+	Match    uint16
+	Mirror   uint16
+	Priority int
+	Device   int
+}
+
+// deviceSnapshot is the state of a device or ticker, which must be the same type when restored.
+type deviceSnapshot struct {
+	Type  string
+	State []byte
+}
+
+type busSnapshot struct {
+	TickCount uint64
+	Mappings  []mappingSnapshot
+	Devices   []deviceSnapshot
+	Tickers   []deviceSnapshot
+}
+
+// devices lists the distinct devices mapped on the bus in the order they are first mapped.
+func (b *Bus) devices() []Addressable {
+	var devices []Addressable
+	seen := map[Addressable]bool{}
+	for _, mem := range b.mems {
+		if !seen[mem.device] {
+			seen[mem.device] = true
+			devices = append(devices, mem.device)
+		}
+	}
+	return devices
+}
+
+func snapshotDevice(v interface{}) (deviceSnapshot, error) {
+	d := deviceSnapshot{Type: fmt.Sprintf("%T", v)}
+	if s, ok := v.(Snapshotter); ok {
+		state, err := s.Snapshot()
+		if err != nil {
+			return d, fmt.Errorf("%s: %w", d.Type, err)
+		}
+		d.State = state
+	}
+	return d, nil
+}
+
+func restoreDevice(v interface{}, d deviceSnapshot) error {
+	if t := fmt.Sprintf("%T", v); t != d.Type {
+		return fmt.Errorf("%w: %s where the snapshot has %s", ErrSnapshotMismatch, t, d.Type)
+	}
+	if s, ok := v.(Snapshotter); ok {
+		if err := s.Restore(d.State); err != nil {
+			return fmt.Errorf("%s: %w", d.Type, err)
+		}
+	}
+	return nil
+}
+
+// Snapshot saves the bus's tick count and mappings along with the state of every mapped device
+// and ticker that implements Snapshotter, which includes the CPU.
+func (b *Bus) Snapshot() ([]byte, error) {
+	s := busSnapshot{TickCount: b.tickCount}
+
+	devices := b.devices()
+	index := map[Addressable]int{}
+	for i, dev := range devices {
+		index[dev] = i
+		d, err := snapshotDevice(dev)
+		if err != nil {
+			return nil, err
+		}
+		s.Devices = append(s.Devices, d)
+	}
+	for _, mem := range b.mems {
+		s.Mappings = append(s.Mappings, mappingSnapshot{
+			Start:    mem.start,
+			End:      mem.end,
+			Mask:     mem.mask,
+			Match:    mem.match,
+			Mirror:   mem.mirror,
+			Priority: mem.priority,
+			Device:   index[mem.device],
+		})
+	}
+	for _, t := range b.tickers {
+		d, err := snapshotDevice(t.ticker)
+		if err != nil {
+			return nil, err
+		}
+		s.Tickers = append(s.Tickers, d)
+	}
+	return encodeSnapshot(s)
+}
+
+// Restore puts the bus back to a snapshot. The bus must have the same number of mappings, devices
+// of the same types and the same tickers as when the snapshot was taken. Mappings are restored in
+// place so handles to them stay valid.
+func (b *Bus) Restore(data []byte) error {
+	var s busSnapshot
+	if err := decodeSnapshot(data, &s); err != nil {
+		return err
+	}
+
+	devices := b.devices()
+	if len(devices) != len(s.Devices) || len(b.mems) != len(s.Mappings) || len(b.tickers) != len(s.Tickers) {
+		return fmt.Errorf("%w: %d mappings of %d devices and %d tickers where the snapshot has %d, %d and %d",
+			ErrSnapshotMismatch, len(b.mems), len(devices), len(b.tickers),
+			len(s.Mappings), len(s.Devices), len(s.Tickers))
+	}
+	for _, m := range s.Mappings {
+		if m.Device < 0 || m.Device >= len(devices) {
+			return fmt.Errorf("%w: mapping of device %d", ErrSnapshotMismatch, m.Device)
+		}
+	}
+	for i, dev := range devices {
+		if err := restoreDevice(dev, s.Devices[i]); err != nil {
+			return err
+		}
+	}
+	for i, t := range b.tickers {
+		if err := restoreDevice(t.ticker, s.Tickers[i]); err != nil {
+			return err
+		}
+	}
+
+	for i, m := range s.Mappings {
+		mem := b.mems[i]
+		mem.start, mem.end = m.Start, m.End
+		mem.mask, mem.match, mem.mirror = m.Mask, m.Match, m.Mirror
+		mem.priority = m.Priority
+		mem.device = devices[m.Device]
+	}
+	b.buildPages()
+	b.tickCount = s.TickCount
+	return nil
+}
+
+type ramSnapshot struct {
+	Bytes []uint8
+}
+
+func (r *Ram) Snapshot() ([]byte, error) {
+	return encodeSnapshot(ramSnapshot{Bytes: r.bytes})
+}
+
+func (r *Ram) Restore(data []byte) error {
+	var s ramSnapshot
+	if err := decodeSnapshot(data, &s); err != nil {
+		return err
+	}
+	if len(s.Bytes) != len(r.bytes) {
+		return fmt.Errorf("%w: %d bytes of RAM where the snapshot has %d", ErrSnapshotMismatch, len(r.bytes), len(s.Bytes))
+	}
+	copy(r.bytes, s.Bytes)
+	return nil
+}
+
+type bankedSnapshot struct {
+	Bank  int
+	Bytes []uint8
+}
+
+// BankedRom only saves which bank is selected.
+func (r *BankedRom) Snapshot() ([]byte, error) {
+	return encodeSnapshot(bankedSnapshot{Bank: r.bank})
+}
+
+func (r *BankedRom) Restore(data []byte) error {
+	var s bankedSnapshot
+	if err := decodeSnapshot(data, &s); err != nil {
+		return err
+	}
+	r.SelectBank(s.Bank)
+	return nil
+}
+
+func (r *BankedRam) Snapshot() ([]byte, error) {
+	return encodeSnapshot(bankedSnapshot{Bank: r.bank, Bytes: r.bytes})
+}
+
+func (r *BankedRam) Restore(data []byte) error {
+	var s bankedSnapshot
+	if err := decodeSnapshot(data, &s); err != nil {
+		return err
+	}
+	if len(s.Bytes) != len(r.bytes) {
+		return fmt.Errorf("%w: %d bytes of RAM where the snapshot has %d", ErrSnapshotMismatch, len(r.bytes), len(s.Bytes))
+	}
+	copy(r.bytes, s.Bytes)
+	r.SelectBank(s.Bank)
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func newFunctionalTestMachine(t *testing.T, accurate bool) (*Bus, *Cpu6502, *Ram) {
+	dat, err := os.ReadFile("functional_tests/6502_functional_test.bin")
+	if err != nil {
+		t.Fatal("Unable to open test binary")
+	}
+	bus := NewBus()
+	ram := NewRam(0x10000)
+	bus.Addressable(0x0000, 0xffff, ram)
+	cpu := NewCpu6502(bus)
+	cpu.CycleAccurate = accurate
+	for a, v := range dat {
+		bus.Write(uint16(a), v)
+	}
+	cpu.PC = 0x0400
+	return bus, cpu, ram
+}
+
+// machineState is enough of the machine to tell whether two runs diverged
+type machineState struct {
+	ticks uint64
+	regs  Registers
+	ram   string
+}
+
+func runMachine(bus *Bus, cpu *Cpu6502, ram *Ram, ticks int) []machineState {
+	var states []machineState
+	for i := 0; i < ticks; i++ {
+		bus.Tick()
+		if i%997 == 0 {
+			states = append(states, machineState{bus.TickCount(), cpu.Registers(), string(ram.bytes)})
+		}
+	}
+	return states
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		bus, cpu, ram := newFunctionalTestMachine(t, accurate)
+		runMachine(bus, cpu, ram, 123457)
+		for accurate && !(cpu.step > 0 && cpu.step < len(cpu.cycles)) {
+			// take the snapshot part way through an instruction
+			bus.Tick()
+		}
+
+		var buf bytes.Buffer
+		if err := WriteSnapshot(&buf, bus); err != nil {
+			t.Fatal(err)
+		}
+		saved := buf.Bytes()
+		expected := runMachine(bus, cpu, ram, 50000)
+
+		// restore into the same machine
+		if err := ReadSnapshot(bytes.NewReader(saved), bus); err != nil {
+			t.Fatal(err)
+		}
+		compareStates(t, "same machine", runMachine(bus, cpu, ram, 50000), expected)
+
+		// and into a new one
+		bus, cpu, ram = newFunctionalTestMachine(t, accurate)
+		if err := ReadSnapshot(bytes.NewReader(saved), bus); err != nil {
+			t.Fatal(err)
+		}
+		compareStates(t, "new machine", runMachine(bus, cpu, ram, 50000), expected)
+	}
+}
+
+func compareStates(t *testing.T, name string, got, expected []machineState) {
+	t.Helper()
+	for i := range expected {
+		if got[i].ticks != expected[i].ticks || got[i].regs != expected[i].regs || got[i].ram != expected[i].ram {
+			t.Fatalf("%s diverged at tick %d: %v, expected %v", name, got[i].ticks, got[i].regs, expected[i].regs)
+		}
+	}
+}
+
+func TestSnapshotMismatch(t *testing.T) {
+	bus := NewBus()
+	bus.Addressable(0x0000, 0x7fff, NewRam(0x8000))
+	NewCpu6502(bus)
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, bus); err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewBus()
+	other.Addressable(0x0000, 0x7fff, NewRam(0x8000))
+	other.Addressable(0x8000, 0xffff, NewRom([]uint8{0x00}))
+	NewCpu6502(other)
+	if err := ReadSnapshot(bytes.NewReader(buf.Bytes()), other); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("restored a different layout with %v", err)
+	}
+
+	other = NewBus()
+	other.Addressable(0x0000, 0x7fff, NewRam(0x8000))
+	NewCpu65C02(other)
+	if err := ReadSnapshot(bytes.NewReader(buf.Bytes()), other); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("restored a different CPU with %v", err)
+	}
+
+	if err := ReadSnapshot(bytes.NewReader([]byte("not a snapshot")), bus); err == nil {
+		t.Errorf("read garbage as a snapshot")
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Symbols names addresses and maps them back to the source lines they were assembled from, for
+// the disassembler and traces. Where several names share an address the first added is used.
+type Symbols struct {
+	names  map[uint16]string
+	values map[string]uint16
+	lines  map[uint16]SourceLine
+}
+
+// SourceLine is a line of a source file.
+type SourceLine struct {
+	File string
+	Line int
+}
+
+func (l SourceLine) String() string { return fmt.Sprintf("%s:%d", l.File, l.Line) }
+
+func NewSymbols() *Symbols {
+	return &Symbols{names: map[uint16]string{}, values: map[string]uint16{}, lines: map[uint16]SourceLine{}}
+}
+
+// Add names addr.
+func (s *Symbols) Add(name string, addr uint16) {
+	if _, ok := s.names[addr]; !ok {
+		s.names[addr] = name
+	}
+	s.values[name] = addr
+}
+
+// AddLine records the source line the code at addr came from.
+func (s *Symbols) AddLine(addr uint16, line SourceLine) {
+	if _, ok := s.lines[addr]; !ok {
+		s.lines[addr] = line
+	}
+}
+
+// Name returns the name of addr.
+func (s *Symbols) Name(addr uint16) (string, bool) {
+	name, ok := s.names[addr]
+	return name, ok
+}
+
+// Lookup returns the address of a name.
+func (s *Symbols) Lookup(name string) (uint16, bool) {
+	addr, ok := s.values[name]
+	return addr, ok
+}
+
+// Line returns the source line the code at addr came from.
+func (s *Symbols) Line(addr uint16) (SourceLine, bool) {
+	line, ok := s.lines[addr]
+	return line, ok
+}
+
+// Len returns the number of names.
+func (s *Symbols) Len() int { return len(s.values) }
+
+// ReadLd65Map adds the exports from a map file written by ld65 with -m. Labels are added before
+// equates so a label wins when both have the same value.
+func (s *Symbols) ReadLd65Map(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	inExports := false
+	var equates [][]string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if strings.HasPrefix(line, "Exports list by name:") {
+			inExports = true
+			continue
+		}
+		if !inExports {
+			continue
+		}
+		if strings.HasSuffix(line, ":") && !strings.HasPrefix(line, " ") {
+			// the next list
+			break
+		}
+		if strings.HasPrefix(line, "---") || line == "" {
+			continue
+		}
+
+		// each line has one or two exports of a name, a hex value and flags such as RLA
+		fields := strings.Fields(line)
+		if len(fields)%3 != 0 {
+			return fmt.Errorf("bad export line %q", line)
+		}
+		for i := 0; i < len(fields); i += 3 {
+			if strings.Contains(fields[i+2], "E") {
+				equates = append(equates, fields[i:i+2])
+			} else if err := s.addHex(fields[i], fields[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, e := range equates {
+		if err := s.addHex(e[0], e[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Symbols) addHex(name string, value string) error {
+	v, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return fmt.Errorf("bad value %q for %s", value, name)
+	}
+	if v <= 0xffff {
+		s.Add(name, uint16(v))
+	}
+	return nil
+}
+
+// ReadViceLabels adds the labels from a VICE monitor label file, with lines such as
+// "al C:0400 .start".
+func (s *Symbols) ReadViceLabels(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "al" {
+			continue
+		}
+		addr := fields[1]
+		if i := strings.Index(addr, ":"); i >= 0 {
+			addr = addr[i+1:]
+		}
+		if err := s.addHex(strings.TrimPrefix(fields[2], "."), addr); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadCa65DbgInfo adds the symbols and source lines from a debug info file written by ca65 and
+// ld65 with --dbgfile. Lines from macro expansions are left out so code maps to where the macro
+// was used.
+func (s *Symbols) ReadCa65DbgInfo(r io.Reader) error {
+	type span struct{ seg, start, size int }
+	files := map[int]string{}
+	segs := map[int]int{}
+	spans := map[int]span{}
+	var lines, syms []map[string]string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		kind, rest, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		attrs, err := parseDbgAttrs(rest)
+		if err != nil {
+			return err
+		}
+		id := dbgInt(attrs["id"])
+		switch kind {
+		case "file":
+			files[id] = attrs["name"]
+		case "seg":
+			segs[id] = dbgInt(attrs["start"])
+		case "span":
+			spans[id] = span{seg: dbgInt(attrs["seg"]), start: dbgInt(attrs["start"]), size: dbgInt(attrs["size"])}
+		case "line":
+			lines = append(lines, attrs)
+		case "sym":
+			syms = append(syms, attrs)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		if l["span"] == "" || l["type"] == "2" {
+			continue
+		}
+		line := SourceLine{File: files[dbgInt(l["file"])], Line: dbgInt(l["line"])}
+		for _, id := range strings.Split(l["span"], "+") {
+			sp, ok := spans[dbgInt(id)]
+			if !ok {
+				continue
+			}
+			start := segs[sp.seg] + sp.start
+			for addr := start; addr < start+sp.size && addr <= 0xffff; addr++ {
+				s.AddLine(uint16(addr), line)
+			}
+		}
+	}
+
+	for _, labels := range []bool{true, false} {
+		for _, sym := range syms {
+			if (sym["type"] == "lab") != labels || sym["val"] == "" {
+				continue
+			}
+			if v := dbgInt(sym["val"]); v >= 0 && v <= 0xffff {
+				s.Add(sym["name"], uint16(v))
+			}
+		}
+	}
+	return nil
+}
+
+// parseDbgAttrs splits the key=value pairs of a line of debug info, unquoting strings.
+func parseDbgAttrs(s string) (map[string]string, error) {
+	attrs := map[string]string{}
+	for s != "" {
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return nil, fmt.Errorf("bad debug info %q", s)
+		}
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in debug info %q", s)
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else if i := strings.Index(rest, ","); i >= 0 {
+			value, rest = rest[:i], rest[i:]
+		} else {
+			value, rest = rest, ""
+		}
+		attrs[key] = value
+		s = strings.TrimPrefix(rest, ",")
+	}
+	return attrs, nil
+}
+
+// dbgInt parses a decimal or 0x hex number from debug info, or returns -1.
+func dbgInt(s string) int {
+	v, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return -1
+	}
+	return int(v)
+}
+
+// FormatOperand formats an operand like the package's FormatOperand, naming the addresses in s.
+func (s *Symbols) FormatOperand(mode string, pc uint16, arg uint16) string {
+	return s.symbolize(FormatOperand(mode, pc, arg), mode, pc+1, arg)
+}
+
+// symbolize replaces the addresses in an operand formatted by mode with their names.
+func (s *Symbols) symbolize(text string, mode string, argAddr uint16, arg uint16) string {
+	replace := func(text string, format string, addr uint16) string {
+		if name, ok := s.names[addr]; ok {
+			return strings.Replace(text, fmt.Sprintf(format, addr), name, 1)
+		}
+		return text
+	}
+	switch mode {
+	case "zp", "zpx", "zpy", "izx", "izy", "izp":
+		return replace(text, "$%02x", arg&0xff)
+	case "abs", "abx", "aby", "ind", "iax":
+		return replace(text, "$%04x", arg)
+	case "rel":
+		return replace(text, "$%04x", argAddr+1+uint16(int8(arg)))
+	case "zpr":
+		text = replace(text, "$%02x", arg&0xff)
+		return replace(text, "$%04x", argAddr+2+uint16(int8(arg>>8)))
+	}
+	return text
+}
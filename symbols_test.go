@@ -0,0 +1,149 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testMap = `Modules list:
+-------------
+test.o:
+    CODE              Offs=000000  Size=000010  Align=00001  Fill=0000
+
+
+Exports list by name:
+---------------------
+PPUCTRL                   002000 REA    reset                     008000 RLA
+screen                    000400 RLA    zp_ptr                    000010 RLZ
+
+
+Exports list by value:
+----------------------
+zp_ptr                    000010 RLZ    screen                    000400 RLA
+`
+
+func TestReadLd65Map(t *testing.T) {
+	s := NewSymbols()
+	if err := s.ReadLd65Map(strings.NewReader(testMap)); err != nil {
+		t.Fatal(err)
+	}
+	for name, addr := range map[string]uint16{"PPUCTRL": 0x2000, "reset": 0x8000, "screen": 0x0400, "zp_ptr": 0x0010} {
+		if v, ok := s.Lookup(name); !ok || v != addr {
+			t.Errorf("%s is $%04x", name, v)
+		}
+	}
+	if s.Len() != 4 {
+		t.Errorf("read %d symbols", s.Len())
+	}
+
+	f, err := os.Open("functional_tests/6502_functional_test.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := NewSymbols().ReadLd65Map(f); err != nil {
+		t.Errorf("reading the functional test's map: %v", err)
+	}
+}
+
+func TestReadViceLabels(t *testing.T) {
+	s := NewSymbols()
+	labels := "al C:0400 .start\nal C:0402 .loop\nbreak 0400\nal 0200 .result\n"
+	if err := s.ReadViceLabels(strings.NewReader(labels)); err != nil {
+		t.Fatal(err)
+	}
+	for name, addr := range map[string]uint16{"start": 0x0400, "loop": 0x0402, "result": 0x0200} {
+		if n, ok := s.Name(addr); !ok || n != name {
+			t.Errorf("$%04x is %q, expected %q", addr, n, name)
+		}
+	}
+}
+
+const testDbg = `version	major=2,minor=0
+info	csym=0,file=1,lib=0,line=4,mod=1,scope=1,seg=1,span=3,sym=3,type=3
+file	id=0,name="test, one.s",size=100,mtime=0x60000000,mod=0
+line	id=0,file=0,line=3,span=0
+line	id=1,file=0,line=4,span=1
+line	id=2,file=0,line=5,span=2
+line	id=3,file=0,line=20,type=2,span=2
+mod	id=0,name="test.o",file=0
+seg	id=0,name="CODE",start=0x000400,size=0x000007,addrsize=absolute,type=rw,oname="test.bin",ooffs=0
+span	id=0,seg=0,start=0,size=2
+span	id=1,seg=0,start=2,size=3
+span	id=2,seg=0,start=5,size=2
+scope	id=0,name="",mod=0,size=7,span=0+1+2
+sym	id=0,name="count",addrsize=zeropage,scope=0,def=0,val=0x8,type=equ
+sym	id=1,name="start",addrsize=absolute,scope=0,def=0,val=0x400,seg=0,type=lab
+sym	id=2,name="@loop",addrsize=absolute,scope=0,parent=1,def=1,val=0x402,seg=0,type=lab
+`
+
+func TestReadCa65DbgInfo(t *testing.T) {
+	s := NewSymbols()
+	if err := s.ReadCa65DbgInfo(strings.NewReader(testDbg)); err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := s.Lookup("@loop"); !ok || addr != 0x0402 {
+		t.Errorf("@loop is $%04x", addr)
+	}
+	for addr, line := range map[uint16]int{0x0400: 3, 0x0402: 4, 0x0404: 4, 0x0405: 5} {
+		if l, ok := s.Line(addr); !ok || l.Line != line || l.File != "test, one.s" {
+			t.Errorf("$%04x is from %v, expected line %d", addr, l, line)
+		}
+	}
+}
+
+func TestSymbolicDisassembly(t *testing.T) {
+	bus := NewBus()
+	ram := NewRam(0x10000)
+	bus.Addressable(0x0000, 0xffff, ram)
+	cpu := NewCpu65C02(bus)
+	cpu.Symbols = NewSymbols()
+	cpu.Symbols.ReadCa65DbgInfo(strings.NewReader(testDbg))
+	cpu.Symbols.Add("result", 0x0200)
+	cpu.Symbols.Add("ptr", 0x0010)
+
+	for _, tc := range []struct {
+		program  []uint8
+		expected string
+	}{
+		{[]uint8{0x8d, 0x00, 0x02}, "STA result"},
+		{[]uint8{0x9d, 0x01, 0x02}, "STA $0201,X"},
+		{[]uint8{0xb1, 0x10}, "LDA (ptr),Y"},
+		{[]uint8{0xd0, 0xfe}, "BNE @loop"},
+		{[]uint8{0x4c, 0x00, 0x04}, "JMP start"},
+		{[]uint8{0x0f, 0x10, 0xfd}, "BBR0 ptr,@loop"},
+		{[]uint8{0xa9, 0x00}, "LDA #$00"},
+	} {
+		for i, b := range tc.program {
+			ram.Write(uint16(0x0402+i), b)
+		}
+		if text, _ := cpu.Disassemble(0x0402); text != tc.expected {
+			t.Errorf("disassembled % x as %q, expected %q", tc.program, text, tc.expected)
+		}
+	}
+
+	var out bytes.Buffer
+	cpu.Tracer = NewTextTracer(&out)
+	cpu.PC = 0x0402
+	step(bus, cpu)
+	if !strings.HasSuffix(out.String(), "; @loop: test, one.s:4\n") {
+		t.Errorf("traced %q", out.String())
+	}
+}
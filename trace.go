@@ -0,0 +1,312 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TraceRecord describes one executed instruction. Addr and Value are worked out before the
+// instruction runs, so Value is what was in memory beforehand even for stores.
+type TraceRecord struct {
+	PC          uint16
+	Bytes       []uint8 // opcode and operand
+	Mnemonic    string  // unofficial opcodes end in *
+	Mode        string  // addressing mode, such as "zpx" or "izy"
+	Disassembly string
+	HasAddr     bool   // whether the instruction has an effective address
+	Addr        uint16 // effective address
+	Value       uint8  // memory at Addr
+	Before      Registers
+	After       Registers
+	Cycles      int    // cycles taken including any penalties
+	Cycle       uint64 // bus cycle the instruction started on
+	Label       string // name of PC, when the CPU has Symbols
+	Source      string // source line of PC as file:line, when the CPU has Symbols
+}
+
+// Tracer is passed a record of each instruction as it finishes. The record is reused, so it must
+// be copied if it is kept after Trace returns.
+type Tracer interface {
+	Trace(r *TraceRecord)
+}
+
+// TracerFunc lets a plain function be used as a Tracer.
+type TracerFunc func(r *TraceRecord)
+
+func (f TracerFunc) Trace(r *TraceRecord) { f(r) }
+
+var debugTracer = NewTextTracer(os.Stdout)
+
+func (cpu *Cpu6502) startTrace() {
+	t := &cpu.trace
+	op := cpu.opCodes[cpu.peek(cpu.PC)]
+
+	t.Disassembly, _ = cpu.Disassemble(cpu.PC)
+	n := 1
+	t.Mnemonic, t.Mode = "", ""
+	if op != nil {
+		n += op.addrMode.args
+		t.Mnemonic, t.Mode = op.mne, op.addrMode.name
+	}
+	for i := 0; i < n; i++ {
+		cpu.traceBytes[i] = cpu.peek(cpu.PC + uint16(i))
+	}
+	t.PC = cpu.PC
+	t.Bytes = cpu.traceBytes[:n]
+	t.Label, t.Source = "", ""
+	if cpu.Symbols != nil {
+		t.Label, _ = cpu.Symbols.Name(cpu.PC)
+		if line, ok := cpu.Symbols.Line(cpu.PC); ok {
+			t.Source = line.String()
+		}
+	}
+	t.HasAddr, t.Addr, t.Value = false, 0, 0
+	if op != nil {
+		var arg uint16
+		if n == 2 {
+			arg = uint16(cpu.traceBytes[1])
+		} else if n == 3 {
+			arg = uint16(cpu.traceBytes[1]) | uint16(cpu.traceBytes[2])<<8
+		}
+		t.Addr, t.HasAddr = cpu.traceAddress(op.addrMode.name, arg)
+		if t.HasAddr {
+			t.Value = cpu.peek(t.Addr)
+		}
+	}
+	t.Before = cpu.Registers()
+	t.Cycles = 0
+	t.Cycle = 0
+	if n := cpu.bus.TickCount(); n > 0 {
+		t.Cycle = n - 1
+	}
+	cpu.tracing = true
+}
+
+func (cpu *Cpu6502) finishTrace() {
+	cpu.tracing = false
+	cpu.trace.After = cpu.Registers()
+	if cpu.Tracer != nil {
+		cpu.Tracer.Trace(&cpu.trace)
+	} else if cpu.Debug {
+		debugTracer.Trace(&cpu.trace)
+	}
+}
+
+// traceAddress works out the effective address of an instruction about to run without side
+// effects.
+func (cpu *Cpu6502) traceAddress(mode string, arg uint16) (uint16, bool) {
+	zpWord := func(ptr uint8) uint16 {
+		return uint16(cpu.peek(uint16(ptr))) | uint16(cpu.peek(uint16(ptr+1)))<<8
+	}
+	switch mode {
+	case "zp", "zpr":
+		return arg & 0xff, true
+	case "zpx":
+		return uint16(uint8(arg) + cpu.X), true
+	case "zpy":
+		return uint16(uint8(arg) + cpu.Y), true
+	case "abs":
+		return arg, true
+	case "abx":
+		return arg + uint16(cpu.X), true
+	case "aby":
+		return arg + uint16(cpu.Y), true
+	case "izx":
+		return zpWord(uint8(arg) + cpu.X), true
+	case "izy":
+		return zpWord(uint8(arg)) + uint16(cpu.Y), true
+	case "izp":
+		return zpWord(uint8(arg)), true
+	case "ind":
+		if cpu.variant != WDC65C02 {
+			// the pointer's high byte is read from the same page
+			return uint16(cpu.peek(arg)) | uint16(cpu.peek(arg&0xff00|(arg+1)&0x00ff))<<8, true
+		}
+		return cpu.peekWord(arg), true
+	case "iax":
+		return cpu.peekWord(arg + uint16(cpu.X)), true
+	}
+	return 0, false
+}
+
+type textTracer struct {
+	w io.Writer
+}
+
+// NewTextTracer writes the trace in the format printed when Debug is set.
+func NewTextTracer(w io.Writer) Tracer {
+	return &textTracer{w: w}
+}
+
+func (t *textTracer) Trace(r *TraceRecord) {
+	if r.Label == "" && r.Source == "" {
+		fmt.Fprintf(t.w, "%04x    %-26s%s\n", r.PC, r.Disassembly, r.After.String())
+		return
+	}
+	fmt.Fprintf(t.w, "%04x    %-26s%s  ; %s\n", r.PC, r.Disassembly, r.After.String(),
+		strings.TrimSpace(labelPrefix(r.Label)+r.Source))
+}
+
+func labelPrefix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return label + ": "
+}
+
+type nestestTracer struct {
+	w io.Writer
+}
+
+// NewNestestTracer writes the trace in the format of the Nintendulator log of nestest, so it can
+// be compared against nestest.log. There is no PPU so the PPU column is left out.
+func NewNestestTracer(w io.Writer) Tracer {
+	return &nestestTracer{w: w}
+}
+
+func (t *nestestTracer) Trace(r *TraceRecord) {
+	bytes := make([]string, len(r.Bytes))
+	for i, b := range r.Bytes {
+		bytes[i] = fmt.Sprintf("%02X", b)
+	}
+	// B only exists on the stack, Nintendulator never shows it in P
+	fmt.Fprintf(t.w, "%04X  %-8s %-32s A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d\n",
+		r.PC, strings.Join(bytes, " "), nestestDisassembly(r),
+		r.Before.A, r.Before.X, r.Before.Y, r.Before.P&^uint8(P_BRK_COMMAND), r.Before.SP, r.Cycle)
+}
+
+// nestestDisassembly formats an instruction the way Nintendulator does, with unofficial opcodes
+// marked by a leading * and the memory each instruction accesses.
+func nestestDisassembly(r *TraceRecord) string {
+	if r.Mnemonic == "" {
+		return " " + strings.ToUpper(r.Disassembly)
+	}
+	mne := " " + r.Mnemonic
+	if strings.HasSuffix(mne, "*") {
+		mne = "*" + strings.TrimSuffix(r.Mnemonic, "*")
+	}
+	if mne == "*ISC" {
+		mne = "*ISB"
+	}
+	value := r.Value
+	if r.Addr >= 0x4000 && r.Addr <= 0x4017 {
+		// Nintendulator shows the APU and controller registers as $ff
+		value = 0xff
+	}
+
+	var arg uint16
+	if len(r.Bytes) == 2 {
+		arg = uint16(r.Bytes[1])
+	} else if len(r.Bytes) == 3 {
+		arg = uint16(r.Bytes[1]) | uint16(r.Bytes[2])<<8
+	}
+
+	var operand string
+	switch r.Mode {
+	case "imp":
+		switch r.Bytes[0] {
+		case 0x0a, 0x2a, 0x4a, 0x6a:
+			operand = "A"
+		}
+	case "imm":
+		operand = fmt.Sprintf("#$%02X", arg)
+	case "zp":
+		operand = fmt.Sprintf("$%02X = %02X", arg, value)
+	case "zpx", "zpy":
+		operand = fmt.Sprintf("$%02X,%s @ %02X = %02X", arg, strings.ToUpper(r.Mode[2:]), r.Addr, value)
+	case "abs":
+		if r.Bytes[0] == 0x4c || r.Bytes[0] == 0x20 {
+			operand = fmt.Sprintf("$%04X", arg)
+		} else {
+			operand = fmt.Sprintf("$%04X = %02X", arg, value)
+		}
+	case "abx", "aby":
+		operand = fmt.Sprintf("$%04X,%s @ %04X = %02X", arg, strings.ToUpper(r.Mode[2:]), r.Addr, value)
+	case "izx":
+		operand = fmt.Sprintf("($%02X,X) @ %02X = %04X = %02X", arg, uint8(arg)+r.Before.X, r.Addr, value)
+	case "izy":
+		operand = fmt.Sprintf("($%02X),Y = %04X @ %04X = %02X", arg, r.Addr-uint16(r.Before.Y), r.Addr, value)
+	case "ind":
+		operand = fmt.Sprintf("($%04X) = %04X", arg, r.Addr)
+	default:
+		// relative branches and the 65C02 modes nestest doesn't use
+		operand = strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(r.Disassembly, r.Mnemonic)))
+	}
+	return strings.TrimRight(mne+" "+operand, " ")
+}
+
+// JSONTracer writes each record as a line of JSON. After a write fails nothing more is written
+// and Err returns the error.
+type JSONTracer struct {
+	enc *json.Encoder
+	err error
+}
+
+// NewJSONTracer writes each record as a line of JSON.
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing the trace.
+func (t *JSONTracer) Err() error { return t.err }
+
+type jsonRecord struct {
+	PC          uint16    `json:"pc"`
+	Bytes       []int     `json:"bytes"`
+	Mnemonic    string    `json:"mnemonic"`
+	Mode        string    `json:"mode"`
+	Disassembly string    `json:"disassembly"`
+	Addr        *uint16   `json:"addr,omitempty"`
+	Value       *uint8    `json:"value,omitempty"`
+	Before      Registers `json:"before"`
+	After       Registers `json:"after"`
+	Cycles      int       `json:"cycles"`
+	Cycle       uint64    `json:"cycle"`
+	Label       string    `json:"label,omitempty"`
+	Source      string    `json:"source,omitempty"`
+}
+
+func (t *JSONTracer) Trace(r *TraceRecord) {
+	if t.err != nil {
+		return
+	}
+	j := jsonRecord{
+		PC:          r.PC,
+		Bytes:       make([]int, len(r.Bytes)),
+		Mnemonic:    r.Mnemonic,
+		Mode:        r.Mode,
+		Disassembly: r.Disassembly,
+		Before:      r.Before,
+		After:       r.After,
+		Cycles:      r.Cycles,
+		Cycle:       r.Cycle,
+		Label:       r.Label,
+		Source:      r.Source,
+	}
+	for i, b := range r.Bytes {
+		j.Bytes[i] = int(b)
+	}
+	if r.HasAddr {
+		addr, value := r.Addr, r.Value
+		j.Addr, j.Value = &addr, &value
+	}
+	t.err = t.enc.Encode(j)
+}
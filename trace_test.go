@@ -0,0 +1,146 @@
+// Copyright (C) 2022 James Grant
+//
+// This is part of munch as 6502 emulator
+//
+// Munch is free software: you can redistribute it and/or modify it under the terms of the GNU
+// General Public License as published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// Munch is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even
+// the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+// General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Munch. If not, see
+// <https://www.gnu.org/licenses/>.
+
+package munch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var traceProgram = []uint8{
+	0xa2, 0x02, //       0600 LDX #$02
+	0xa0, 0x01, //       0602 LDY #$01
+	0x95, 0x10, //       0604 STA $10,X
+	0xb1, 0x20, //       0606 LDA ($20),Y
+	0x4a,       //       0608 LSR A
+	0xa7, 0x12, //       0609 LAX* $12
+	0x6c, 0x30, 0x00, // 060b JMP ($0030)
+}
+
+func runTrace(t *testing.T, tracer Tracer, n int) *Cpu6502 {
+	bus, cpu := newTestCpu(traceProgram)
+	bus.Write(0x0012, 0x55)
+	bus.Write(0x0020, 0xff)
+	bus.Write(0x0021, 0x01)
+	bus.Write(0x0200, 0x81)
+	bus.Write(0x0030, 0x00)
+	bus.Write(0x0031, 0x06)
+	cpu.A, cpu.P = 0x00, 0x24
+	cpu.Tracer = tracer
+	for i := 0; i < n; i++ {
+		for bus.Tick(); cpu.Waiting(); bus.Tick() {
+		}
+	}
+	return cpu
+}
+
+func TestTextTracer(t *testing.T) {
+	var out bytes.Buffer
+	runTrace(t, NewTextTracer(&out), 2)
+	expected := "0600    LDX #$02                  PC:0602 A:00 X:02 Y:00 SP:fd nv-bdIzc\n" +
+		"0602    LDY #$01                  PC:0604 A:00 X:02 Y:01 SP:fd nv-bdIzc\n"
+	if out.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
+
+func TestNestestTracer(t *testing.T) {
+	var out bytes.Buffer
+	runTrace(t, NewNestestTracer(&out), 7)
+	expected := []string{
+		"0600  A2 02     LDX #$02                        A:00 X:00 Y:00 P:24 SP:FD CYC:0",
+		"0602  A0 01     LDY #$01                        A:00 X:02 Y:00 P:24 SP:FD CYC:2",
+		"0604  95 10     STA $10,X @ 12 = 55             A:00 X:02 Y:01 P:24 SP:FD CYC:4",
+		"0606  B1 20     LDA ($20),Y = 01FF @ 0200 = 81  A:00 X:02 Y:01 P:24 SP:FD CYC:8",
+		"0608  4A        LSR A                           A:81 X:02 Y:01 P:A4 SP:FD CYC:14",
+		"0609  A7 12    *LAX $12 = 00                    A:40 X:02 Y:01 P:25 SP:FD CYC:16",
+		"060B  6C 30 00  JMP ($0030) = 0600              A:00 X:00 Y:01 P:27 SP:FD CYC:19",
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("got %d lines\n%s", len(lines), out.String())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("got\n%s\nexpected\n%s", lines[i], expected[i])
+		}
+	}
+}
+
+func TestNestestTracerApuRegisters(t *testing.T) {
+	// LDA #$00, STA $4015, LDA $4016, NOP
+	bus, cpu, _, _ := newTest2A03(true, 0xa9, 0x00, 0x8d, 0x15, 0x40, 0xad, 0x16, 0x40, 0xea)
+	var trace bytes.Buffer
+	cpu.Tracer = NewNestestTracer(&trace)
+	for i := 0; i < 4; i++ {
+		step(bus, cpu)
+	}
+	for _, want := range []string{"STA $4015 = FF", "LDA $4016 = FF"} {
+		if !strings.Contains(trace.String(), want) {
+			t.Errorf("trace has no %q\n%s", want, trace.String())
+		}
+	}
+}
+
+func TestJSONTracer(t *testing.T) {
+	var out bytes.Buffer
+	runTrace(t, NewJSONTracer(&out), 4)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines\n%s", len(lines), out.String())
+	}
+	var r struct {
+		PC     uint16
+		Bytes  []int
+		Mode   string
+		Addr   *uint16
+		Value  *uint8
+		Before Registers
+		After  Registers
+		Cycles int
+	}
+	if err := json.Unmarshal([]byte(lines[3]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.PC != 0x0606 || len(r.Bytes) != 2 || r.Mode != "izy" || r.Addr == nil || *r.Addr != 0x0200 ||
+		*r.Value != 0x81 || r.Before.A != 0x00 || r.After.A != 0x81 || r.Cycles != 6 {
+		t.Errorf("unexpected record %s", lines[3])
+	}
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("disk full")
+}
+
+func TestJSONTracerError(t *testing.T) {
+	w := &failingWriter{}
+	tracer := NewJSONTracer(w)
+	runTrace(t, tracer, 4)
+	if err := tracer.Err(); err == nil || err.Error() != "disk full" {
+		t.Errorf("Err returned %v", err)
+	}
+	if w.writes != 1 {
+		t.Errorf("wrote %d times after the first write failed", w.writes-1)
+	}
+}